@@ -0,0 +1,89 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+// archive extensions searched with --archive
+var archiveExts = []string{".tar", ".tar.gz", ".tgz", ".zip"}
+
+func isArchive(path string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchArchive searches the regular files in an archive as if they were
+// on disk, named archive!member. Archives in archives aren't opened.
+func searchArchive(path string, out io.Writer, printer PrinterFn) {
+	if replacing != nil {
+		warn("%s: can't replace inside archives", path)
+		return
+	}
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		searchZipArchive(path, out, printer)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		warn("%v", err)
+		return
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if !strings.HasSuffix(strings.ToLower(path), ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			warn("%s: %v", path, err)
+			return
+		}
+		defer gz.Close()
+		in = gz
+	}
+	tr := tar.NewReader(in)
+	for !exhausted() {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			warn("%s: %v", path, err)
+			return
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			searchInput(hdr.Name, path+"!"+hdr.Name, stoppable(tr, false), nil, out, printer)
+		}
+	}
+}
+
+func searchZipArchive(path string, out io.Writer, printer PrinterFn) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		warn("%v", err)
+		return
+	}
+	defer r.Close()
+	for _, member := range r.File {
+		if exhausted() {
+			return
+		}
+		if !member.Mode().IsRegular() {
+			continue
+		}
+		in, err := member.Open()
+		if err != nil {
+			warn("%s!%s: %v", path, member.Name, err)
+			continue
+		}
+		searchInput(member.Name, path+"!"+member.Name, stoppable(in, false), nil, out, printer)
+		in.Close()
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const usageLine = "Usage: sgrep [OPTION]... PATTERN [FILE]..."
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usageLine)
+		flag.PrintDefaults()
+	}
+}
+
+// parseArgs sets the flags in args the way GNU tools take them: options
+// and operands in any order until --, short options combined as in -in 2,
+// -C3 or -iN=false, and long ones as --name value or --name=value. A single dash
+// before a long name, -name, still works like with package flag.
+// Returns the operands.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(operands, args[i+1:]...), nil
+		case arg == "-" || !strings.HasPrefix(arg, "-"):
+			operands = append(operands, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		dashes := arg[:len(arg)-len(strings.TrimLeft(arg, "-"))]
+		if dashes == "--" || fs.Lookup(name) != nil || isHelp(name) {
+			f, err := lookupFlag(fs, dashes+name)
+			if err != nil {
+				return nil, err
+			}
+			if !hasValue && !isBoolFlag(f) {
+				if i+1 == len(args) {
+					return nil, fmt.Errorf("flag needs an argument: %s", arg)
+				}
+				i++
+				value = args[i]
+			} else if !hasValue {
+				value = "true"
+			}
+			if err := fs.Set(f.Name, value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for %s: %v", value, arg, err)
+			}
+			continue
+		}
+		// a cluster of short options, the first taking a value ends it,
+		// the last one may be given a value as in -iN=false
+		for j := 1; j < len(arg); j++ {
+			f, err := lookupFlag(fs, "-"+arg[j:j+1])
+			if err != nil {
+				return nil, err
+			}
+			value := "true"
+			if isBoolFlag(f) && strings.HasPrefix(arg[j+1:], "=") {
+				value, j = arg[j+2:], len(arg)
+			} else if !isBoolFlag(f) {
+				if value = arg[j+1:]; value == "" {
+					if i+1 == len(args) {
+						return nil, fmt.Errorf("flag needs an argument: -%s", f.Name)
+					}
+					i++
+					value = args[i]
+				}
+				j = len(arg)
+			}
+			if err := fs.Set(f.Name, value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for -%s: %v", value, f.Name, err)
+			}
+		}
+	}
+	return operands, nil
+}
+
+// lookupFlag finds the flag of an option as written, with its dashes.
+// Asking for help prints the usage and exits.
+func lookupFlag(fs *flag.FlagSet, option string) (*flag.Flag, error) {
+	name := strings.TrimLeft(option, "-")
+	if f := fs.Lookup(name); f != nil {
+		return f, nil
+	}
+	if isHelp(name) {
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		os.Exit(0)
+	}
+	return nil, fmt.Errorf("flag provided but not defined: %s", option)
+}
+
+func isHelp(name string) bool { return name == "h" || name == "help" }
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args     string
+		flags    string // i, N, n and e after parsing
+		operands string
+		err      bool
+	}{
+		{"pat file", "false true 1 ", "pat file", false},
+		{"-in 2 pat", "true true 2 ", "pat", false},
+		{"-C3 pat", "false true 1 ", "pat", false},
+		{"-iN=false pat", "true false 1 ", "pat", false},
+		{"-Ni=false pat", "false true 1 ", "pat", false},
+		{"-ie x", "true true 1 x", "", false},
+		{"-iex=y", "true true 1 x=y", "", false},
+		{"pat -i file -n 3", "true true 3 ", "pat file", false},
+		{"-i -- -n file", "true true 1 ", "-n file", false},
+		{"- -i", "true true 1 ", "-", false},
+		{"--scopes=4 pat", "false true 4 ", "pat", false},
+		{"--scopes 4 pat", "false true 4 ", "pat", false},
+		{"-scopes 4 pat", "false true 4 ", "pat", false},
+		{"-N=false pat", "false false 1 ", "pat", false},
+		{"-x pat", "", "", true},
+		{"-ix pat", "", "", true},
+		{"pat -n", "", "", true},
+		{"-iN=maybe pat", "", "", true},
+		{"--scopes", "", "", true},
+	}
+	for _, tc := range tests {
+		fs := flag.NewFlagSet("sgrep", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		i := fs.Bool("i", false, "")
+		n := fs.Bool("N", true, "")
+		scopes := fs.Uint("n", 1, "")
+		fs.UintVar(scopes, "scopes", 1, "")
+		fs.Int("C", 0, "")
+		e := fs.String("e", "", "")
+		operands, err := parseArgs(fs, strings.Fields(tc.args))
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: no error", tc.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.args, err)
+			continue
+		}
+		if flags := fmt.Sprintf("%v %v %v %s", *i, *n, *scopes, *e); flags != tc.flags {
+			t.Errorf("%q: got flags %q, want %q", tc.args, flags, tc.flags)
+		}
+		if got := strings.Join(operands, " "); got != tc.operands {
+			t.Errorf("%q: got operands %q, want %q", tc.args, got, tc.operands)
+		}
+	}
+}
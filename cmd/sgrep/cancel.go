@@ -0,0 +1,94 @@
+package main
+
+import (
+	gocontext "context" // context is the -C flag
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// ctx is done on the first interrupt or past --timeout. Searching stops
+// then, inputs being read end there and the scopes found so far are
+// still written out. A second interrupt kills sgrep as usual.
+var ctx = gocontext.Background()
+
+func startContext(timeout time.Duration) {
+	var stop, cancel func()
+	ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+	cancel = func() {}
+	if timeout > 0 {
+		ctx, cancel = gocontext.WithTimeout(ctx, timeout)
+	}
+	go func() {
+		<-ctx.Done()
+		stop()
+		cancel()
+	}()
+}
+
+// cancelled checks if searching must stop
+func cancelled() bool {
+	select {
+	case <-ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func timedOut() bool {
+	return errors.Is(ctx.Err(), gocontext.DeadlineExceeded)
+}
+
+// stoppable makes an input end once searching must stop. Reads from pipes
+// and terminals may block for good, they're done in the background so
+// they can be left waiting.
+func stoppable(r io.Reader, blocking bool) io.Reader {
+	if blocking {
+		return &backgroundReader{r: r, done: make(chan int, 1)}
+	}
+	return stopReader{r}
+}
+
+type stopReader struct{ r io.Reader }
+
+func (s stopReader) Read(p []byte) (int, error) {
+	if cancelled() {
+		return 0, io.EOF
+	}
+	return s.r.Read(p)
+}
+
+type backgroundReader struct {
+	r       io.Reader
+	buf     []byte // read into by the background read
+	err     error
+	done    chan int // bytes read
+	reading bool
+}
+
+func (b *backgroundReader) Read(p []byte) (int, error) {
+	if !b.reading {
+		if cancelled() {
+			return 0, io.EOF
+		}
+		if len(b.buf) < len(p) {
+			b.buf = make([]byte, len(p))
+		}
+		b.reading = true
+		go func(buf []byte) {
+			n, err := b.r.Read(buf)
+			b.err = err
+			b.done <- n
+		}(b.buf[:len(p)])
+	}
+	select {
+	case n := <-b.done:
+		b.reading = false
+		return copy(p, b.buf[:n]), b.err
+	case <-ctx.Done():
+		return 0, io.EOF
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// enableColors is a no-op, terminals take ANSI escape sequences
+func enableColors(f *os.File) bool {
+	return true
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const enableVirtualTerminalProcessing = 0x0004
+
+var setConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// enableColors turns on ANSI escape sequences on a windows console, older
+// consoles without them get no colors
+func enableColors(f *os.File) bool {
+	var mode uint32
+	h := syscall.Handle(f.Fd())
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return true // not a console, a file or a pipe to a terminal emulator
+	}
+	if mode&enableVirtualTerminalProcessing != 0 {
+		return true
+	}
+	ok, _, _ := setConsoleMode.Call(uintptr(h), uintptr(mode|enableVirtualTerminalProcessing))
+	return ok != 0
+}
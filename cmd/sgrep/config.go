@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// config files hold default flags, colors and language definitions in a
+// subset of TOML: [sections], comments and single line string, number,
+// boolean or array values. The one in the repository root is read after
+// ~/.sgreprc, command line flags override both. A repository comes from
+// anywhere, its config only sets the flags in repoFlags.
+//
+//	flags = ["-n", "2", "--gitignore"]
+//
+//	[colors]
+//	match = "1;31"
+//
+//	[lang.pascal]
+//	exts = [".pas"]
+//	delims = ["begin:end"]
+//	line-comments = ["//"]
+//	block-comments = ["{:}", "(*:*)"]
+//	literals = ["[[:]]", "`:`"]
+//
+//	[lang.crystal]
+//	exts = [".cr"]
+//	delims = ["def:end", "class:end", "if:end", "do:end"]
+//	keywords = true
+//	statements = ["def", "class", "if"]
+type config map[string]map[string][]string
+
+const rcFile, repoConfig = ".sgreprc", ".sgrep.toml"
+
+// configFiles are the config files found for the working directory
+func configFiles() []string {
+	var paths []string
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, rcFile))
+	}
+	// the repository root is the closest directory with a .git or config
+	dir, err := os.Getwd()
+	for err == nil {
+		if _, err := os.Stat(filepath.Join(dir, repoConfig)); err == nil {
+			return append(paths, filepath.Join(dir, repoConfig))
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return paths
+}
+
+// repoFlags are the flags a repository config may set, those choosing
+// what's searched and how it's shown. Flags writing files, listening or
+// running on their own only come from ~/.sgreprc or the command line.
+var repoFlags = []string{
+	"scopes", "n", "ancestor", "keep", "kind", "depth", "min-depth", "max-depth",
+	"max-depth-hard", "min-lines", "max-lines", "require-scope", "match-on", "all",
+	"i", "ignore-case", "w", "word-regexp", "F", "fixed-strings", "regex-engine",
+	"lang", "mode", "engine", "delim", "begin", "end", "nesting", "record-start",
+	"literals", "escapes", "comment-scopes", "code-only", "comments-only",
+	"strings-only", "multiline", "strict", "encoding", "binary", "mmap",
+	"r", "recursive", "include", "exclude", "gitignore", "z", "search-zip",
+	"j", "jobs", "m", "max-count", "max-scope-lines", "max-buffer-bytes",
+	"A", "B", "C", "after-context", "before-context", "context",
+	"format", "color", "pretty", "gutter", "fold-depth", "breadcrumbs", "siblings",
+	"headers-only", "closing", "only", "column", "column-mode", "tab-width",
+	"N", "line-number", "no-line-number", "c", "count", "count-matches", "l", "files-with-matches",
+	"L", "files-without-match", "v", "invert-scope", "0", "null", "dedupe", "stats",
+}
+
+// loadConfig reads the config files, setting their flags
+func loadConfig() error {
+	for _, path := range configFiles() {
+		cfg, err := readConfig(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		fs := flag.CommandLine
+		if filepath.Base(path) == repoConfig {
+			fs = restrictedFlags()
+		}
+		operands, err := parseArgs(fs, cfg[""]["flags"])
+		if err == nil && len(operands) > 0 {
+			err = fmt.Errorf("unexpected %q in flags", operands[0])
+		}
+		if err != nil && fs != flag.CommandLine {
+			return fmt.Errorf("%s: %v, repository configs only set search and display flags", path, err)
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if err := cfg.apply(); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// restrictedFlags is a flag set with only the repoFlags, setting the same
+// values as the command line
+func restrictedFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("sgrep", flag.ContinueOnError)
+	for _, name := range repoFlags {
+		f := flag.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	return fs
+}
+
+func readConfig(path string) (config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := config{"": {}}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#':
+			continue
+		case line[0] == '[' && line[len(line)-1] == ']':
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if cfg[section] == nil {
+				cfg[section] = make(map[string][]string)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		values, err := parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		cfg[section][strings.TrimSpace(key)] = values
+	}
+	return cfg, scanner.Err()
+}
+
+// parseValue reads a value or an array of them as strings
+func parseValue(v string) ([]string, error) {
+	if !strings.HasPrefix(v, "[") {
+		s, rest, err := parseScalar(v)
+		if err == nil && rest != "" && rest[0] != '#' {
+			err = fmt.Errorf("unexpected %q", rest)
+		}
+		return []string{s}, err
+	}
+	var values []string
+	v = strings.TrimSpace(v[1:])
+	for !strings.HasPrefix(v, "]") {
+		s, rest, err := parseScalar(v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+		if rest = strings.TrimPrefix(rest, ","); rest == "" {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		v = strings.TrimSpace(rest)
+	}
+	return values, nil
+}
+
+// parseScalar reads a leading string, number or boolean from v
+func parseScalar(v string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		prefix, err := strconv.QuotedPrefix(v)
+		if err != nil {
+			return "", "", fmt.Errorf("bad string %s", v)
+		}
+		s, _ := strconv.Unquote(prefix)
+		return s, strings.TrimSpace(v[len(prefix):]), nil
+	case strings.HasPrefix(v, "'"):
+		// literal strings, no escapes
+		end := strings.IndexByte(v[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("bad string %s", v)
+		}
+		return v[1 : end+1], strings.TrimSpace(v[end+2:]), nil
+	}
+	end := strings.IndexAny(v, ",] \t#")
+	if end < 0 {
+		end = len(v)
+	}
+	if end == 0 {
+		return "", "", fmt.Errorf("missing value")
+	}
+	return v[:end], strings.TrimSpace(v[end:]), nil
+}
+
+// apply the colors and languages of a config
+func (cfg config) apply() error {
+	for name, code := range cfg["colors"] {
+		c, ok := palette[name]
+		if !ok || len(code) != 1 {
+			return fmt.Errorf("unknown color %q", name)
+		}
+		// anything else would be written to terminals as is, a config
+		// from a repository could send them any escape sequence
+		if code[0] == "" || strings.Trim(code[0], "0123456789;") != "" {
+			return fmt.Errorf("bad color %q for %s, expected SGR codes like 1;31", code[0], name)
+		}
+		*c = "\033[" + code[0] + "m"
+	}
+	for section, keys := range cfg {
+		if !strings.HasPrefix(section, "lang.") {
+			continue
+		}
+		l, err := configLanguage(strings.TrimPrefix(section, "lang."), keys)
+		if err != nil {
+			return err
+		}
+		// shadow any builtin language with the same name
+		sgrep.Languages = append([]*sgrep.Language{l}, sgrep.Languages...)
+	}
+	return nil
+}
+
+// configLanguage defines a language, starting from the builtin with the
+// same name if any
+func configLanguage(name string, keys map[string][]string) (*sgrep.Language, error) {
+	l := &sgrep.Language{Name: name}
+	if builtin, err := sgrep.LookupLanguage(name); err == nil {
+		*l = *builtin
+	}
+	for key, values := range keys {
+		var err error
+		switch key {
+		case "exts":
+			l.Exts = values
+		case "mode":
+			l.Mode = strings.Join(values, "")
+		case "quotes":
+			l.Quotes = strings.Join(values, "")
+		case "delims":
+			l.Delims, err = configPairs(values)
+		case "line-comments":
+			l.LineComments = values
+		case "block-comments":
+			l.BlockComments, err = configPairs(values)
+		case "literals":
+			// unlike delimiters literals often close with what opens them
+			l.Literals = nil
+			for _, v := range values {
+				open, close, ok := strings.Cut(v, ":")
+				if !ok || open == "" || close == "" {
+					err = fmt.Errorf("expected OPEN:CLOSE, got %q", v)
+				}
+				l.Literals = append(l.Literals, [2]string{open, close})
+			}
+		case "heredocs":
+			l.Heredocs, err = strconv.ParseBool(strings.Join(values, ""))
+		case "escapes":
+			l.Escapes, err = strconv.ParseBool(strings.Join(values, ""))
+		case "chars":
+			l.Chars = values
+		case "preprocessor":
+			l.Preprocessor, err = strconv.ParseBool(strings.Join(values, ""))
+		case "keywords":
+			l.Keywords, err = strconv.ParseBool(strings.Join(values, ""))
+		case "statements":
+			l.Statements = values
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("lang.%s: %v", name, err)
+		}
+	}
+	return l, nil
+}
+
+// configPairs parses OPEN:CLOSE values like --delim
+func configPairs(values []string) ([][2]string, error) {
+	var pairs delimList
+	for _, v := range values {
+		if err := pairs.Set(v); err != nil {
+			return nil, err
+		}
+	}
+	return pairs, nil
+}
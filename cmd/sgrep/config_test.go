@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestConfigColors(t *testing.T) {
+	defer func(saved string) { colorMatch = saved }(colorMatch)
+	tests := []struct {
+		code string
+		ok   bool
+	}{
+		{"1;31", true},
+		{"0", true},
+		{"", false},
+		{"1;31m\033]0;pwned\007", false},
+		{"31m\033[2J", false},
+	}
+	for _, tc := range tests {
+		err := config{"colors": {"match": {tc.code}}}.apply()
+		if (err == nil) != tc.ok {
+			t.Errorf("color %q: got error %v", tc.code, err)
+		}
+		if tc.ok && colorMatch != "\033["+tc.code+"m" {
+			t.Errorf("color %q: got %q", tc.code, colorMatch)
+		}
+	}
+}
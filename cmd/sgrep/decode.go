@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encodings known by --encoding, auto looks for a byte order mark
+var encodings = []string{"auto", "utf-8", "utf-16le", "utf-16be"}
+
+// transcoded are the names of inputs decoded from utf-16, their columns are
+// reported in runes as byte offsets of the utf-8 text mean nothing to users
+var transcoded sync.Map
+
+// decode wraps in to read utf-8 whatever the encoding of the input, which
+// is returned too
+func decode(name string, in *bufio.Reader, encoding string) (*bufio.Reader, textEncoding, error) {
+	enc, err := sniffEncoding(in, encoding)
+	if err != nil {
+		return nil, enc, err
+	}
+	// a byte order mark isn't part of the text
+	in.Discard(len(enc.bom))
+	if enc.order == nil {
+		return in, enc, nil
+	}
+	transcoded.Store(name, true)
+	return bufio.NewReader(&utf16Reader{in: in, order: enc.order}), enc, nil
+}
+
+// textEncoding is how an input is encoded, --write encodes the replaced
+// lines back the same way
+type textEncoding struct {
+	order binary.ByteOrder // of utf-16, nil for utf-8
+	bom   []byte           // byte order mark the input starts with, if any
+}
+
+// sniffEncoding peeks at the start of in for the byte order mark of the
+// encoding, or any of them if auto
+func sniffEncoding(in *bufio.Reader, encoding string) (textEncoding, error) {
+	head, _ := in.Peek(3)
+	boms := []struct {
+		encoding string
+		bom      []byte
+		order    binary.ByteOrder
+	}{
+		{"utf-8", []byte{0xef, 0xbb, 0xbf}, nil},
+		{"utf-16le", []byte{0xff, 0xfe}, binary.LittleEndian},
+		{"utf-16be", []byte{0xfe, 0xff}, binary.BigEndian},
+	}
+	for _, b := range boms {
+		switch {
+		case encoding == "auto" && bytes.HasPrefix(head, b.bom):
+			return textEncoding{b.order, b.bom}, nil
+		case encoding == b.encoding && b.order != nil:
+			enc := textEncoding{order: b.order}
+			if bytes.HasPrefix(head, b.bom) {
+				enc.bom = b.bom
+			}
+			return enc, nil
+		}
+	}
+	if encoding == "auto" || encoding == "utf-8" {
+		// a bom is left as text when utf-8 is asked for
+		return textEncoding{}, nil
+	}
+	return textEncoding{}, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// encode converts lines of utf-8 back to the encoding, the first one gets
+// the byte order mark
+func (e textEncoding) encode(lines [][]byte) [][]byte {
+	if e.order == nil && e.bom == nil {
+		return lines
+	}
+	encoded := make([][]byte, len(lines))
+	for i, line := range lines {
+		var b []byte
+		if i == 0 {
+			b = append(b, e.bom...)
+		}
+		if e.order == nil {
+			encoded[i] = append(b, line...)
+			continue
+		}
+		var unit [2]byte
+		for _, u := range utf16.Encode([]rune(string(line))) {
+			e.order.PutUint16(unit[:], u)
+			b = append(b, unit[:]...)
+		}
+		encoded[i] = b
+	}
+	return encoded
+}
+
+// utf16Reader transcodes utf-16 to utf-8
+type utf16Reader struct {
+	in    *bufio.Reader
+	order binary.ByteOrder
+	buf   []byte // utf-8 not returned yet
+}
+
+func (r *utf16Reader) Read(p []byte) (int, error) {
+	for len(r.buf) < len(p) {
+		u, err := r.unit()
+		if err != nil {
+			if len(r.buf) > 0 {
+				break
+			}
+			return 0, err
+		}
+		c := rune(u)
+		if utf16.IsSurrogate(c) {
+			if low, err := r.unit(); err == nil {
+				c = utf16.DecodeRune(c, rune(low))
+			} else {
+				c = utf8.RuneError
+			}
+		}
+		r.buf = utf8.AppendRune(r.buf, c)
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// unit reads the next 16 bit code unit, an odd byte at the end is an error
+func (r *utf16Reader) unit() (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(r.in, b[:]); err == io.ErrUnexpectedEOF {
+		return utf8.RuneError, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return r.order.Uint16(b[:]), nil
+}
+
+// columnOf converts a byte offset in a line to the unit of --column-mode,
+// inputs decoded from utf-16 count runes unless told otherwise
+func columnOf(name string, text []byte, col uint) uint {
+	if int(col) > len(text) {
+		return col
+	}
+	mode := *columnMode
+	if _, ok := transcoded.Load(name); ok && mode == "bytes" {
+		mode = "runes"
+	}
+	switch mode {
+	case "runes":
+		return uint(utf8.RuneCount(text[:col]))
+	case "visual":
+		// tabs move to the next tab stop
+		n := uint(0)
+		for _, r := range string(text[:col]) {
+			if r == '\t' && *tabWidth > 0 {
+				n += *tabWidth - n%*tabWidth
+			} else {
+				n++
+			}
+		}
+		return n
+	}
+	return col
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// compression formats by magic number, those without a reader in the
+// standard library are piped through their command line tool
+var compressions = []struct {
+	magic []byte
+	ext   string
+	cmd   []string
+}{
+	{[]byte{0x1f, 0x8b}, ".gz", nil},
+	{[]byte("BZh"), ".bz2", nil},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, ".zst", []string{"zstd", "-dcq"}},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0}, ".xz", []string{"xz", "-dc"}},
+}
+
+// decompress wraps in with a decompressing reader if it starts with a
+// known magic number. done waits for external decompressors to finish.
+func decompress(in *bufio.Reader) (r *bufio.Reader, done func() error, err error) {
+	head, _ := in.Peek(8)
+	for _, c := range compressions {
+		if !bytes.HasPrefix(head, c.magic) {
+			continue
+		}
+		var dec io.Reader
+		switch c.ext {
+		case ".gz":
+			if dec, err = gzip.NewReader(in); err != nil {
+				return nil, nil, err
+			}
+		case ".bz2":
+			dec = bzip2.NewReader(in)
+		default:
+			cmd := exec.Command(c.cmd[0], c.cmd[1:]...)
+			cmd.Stdin = in
+			out, err := cmd.StdoutPipe()
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := cmd.Start(); err != nil {
+				return nil, nil, err
+			}
+			return bufio.NewReader(out), func() error {
+				// stopping early, ie: with -l, isn't an error of the tool
+				if n, err := out.Read(make([]byte, 1)); n > 0 || err != io.EOF {
+					cmd.Process.Kill()
+					cmd.Wait()
+					return nil
+				}
+				return cmd.Wait()
+			}, nil
+		}
+		return bufio.NewReader(dec), func() error { return nil }, nil
+	}
+	return in, func() error { return nil }, nil
+}
+
+// uncompressedName drops a compression extension, so the language of
+// main.go.gz is detected as go
+func uncompressedName(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, c := range compressions {
+		if ext == c.ext {
+			return strings.TrimSuffix(path, filepath.Ext(path))
+		}
+	}
+	return path
+}
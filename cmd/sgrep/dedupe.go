@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// dupes are the scopes printed with --dedupe by the hash of their body
+type dupes struct {
+	sync.Mutex
+	seen  map[uint64]*dupe
+	order []*dupe // first seen first
+}
+
+// dupe is where a scope was printed and the files it's in
+type dupe struct {
+	name  string
+	line  uint // 1-based
+	files []string
+}
+
+var printed = dupes{seen: make(map[uint64]*dupe)}
+
+// withDedupe only prints scopes whose body wasn't printed yet
+func withDedupe(printer PrinterFn) PrinterFn {
+	return func(out io.Writer, name string, r *sgrep.Result) {
+		if printed.first(name, r) {
+			printer(out, name, r)
+		}
+	}
+}
+
+// first tells if the scope of r wasn't seen before, noting its file
+func (d *dupes) first(name string, r *sgrep.Result) bool {
+	h := scopeHash(r)
+	d.Lock()
+	defer d.Unlock()
+	if s, ok := d.seen[h]; ok {
+		if s.files[len(s.files)-1] != name {
+			s.files = append(s.files, name)
+		}
+		return false
+	}
+	s := &dupe{name: name, line: r.Scope.Start.Line.Num + 1, files: []string{name}}
+	d.seen[h] = s
+	d.order = append(d.order, s)
+	return true
+}
+
+// scopeHash hashes a scope, from its opening delimiter to the end of its
+// closing one, without whitespace. Changes in indentation or wrapping
+// don't make copies different.
+func scopeHash(r *sgrep.Result) uint64 {
+	h := fnv.New64a()
+	s := r.Scope
+	buf := make([]byte, 0, 256)
+	for _, line := range r.Lines {
+		text := line.Text
+		if line.Num < s.Start.Line.Num || s.End != nil && line.Num > s.End.Line.Num {
+			continue // context
+		}
+		if s.End != nil && line == s.End.Line {
+			text = text[:min(int(s.End.Col)+len(s.End.Delim.Str), len(text))]
+		}
+		if line == s.Start.Line {
+			text = text[min(int(s.Start.Col), len(text)):]
+		}
+		buf = buf[:0]
+		for _, c := range text {
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != '\v' && c != '\f' {
+				buf = append(buf, c)
+			}
+		}
+		h.Write(buf)
+	}
+	return h.Sum64()
+}
+
+// write tells the files of the scopes seen in several, then starts over
+func (d *dupes) write(out io.Writer) {
+	d.Lock()
+	defer d.Unlock()
+	for _, s := range d.order {
+		if len(s.files) > 1 {
+			fmt.Fprintf(out, "%s:%d: seen in %d files: %s\n", s.name, s.line, len(s.files), strings.Join(s.files, ", "))
+		}
+	}
+	d.seen, d.order = make(map[uint64]*dupe), nil
+}
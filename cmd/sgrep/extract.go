@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// captureNames are the named groups of the patterns, in order of
+// appearance and without repeats, the columns of --extract output
+var captureNames []string
+
+// extractors are the patterns with named groups
+var extractors []*regexp.Regexp
+
+// initExtract finds the named groups of the patterns for --extract
+func initExtract() error {
+	seen := make(map[string]bool)
+	for _, p := range patterns {
+		re, ok := p.(*regexp.Regexp)
+		if !ok {
+			continue
+		}
+		named := false
+		for _, name := range re.SubexpNames() {
+			if name != "" && !seen[name] {
+				captureNames = append(captureNames, name)
+				seen[name] = true
+			}
+			named = named || name != ""
+		}
+		if named {
+			extractors = append(extractors, re)
+		}
+	}
+	if len(extractors) == 0 {
+		return fmt.Errorf("--extract needs a regular expression with named groups like (?P<name>...)")
+	}
+	return nil
+}
+
+// extractRecord is written for every match with --extract --format json
+type extractRecord struct {
+	File     string            `json:"file"`
+	Line     uint              `json:"line"`
+	Column   uint              `json:"column"`
+	Scope    extractScope      `json:"scope"`
+	Captures map[string]string `json:"captures"`
+}
+
+type extractScope struct {
+	Start jsonPos  `json:"start"`
+	End   *jsonPos `json:"end"`
+	Depth int      `json:"depth"`
+	Kind  string   `json:"kind,omitempty"`
+}
+
+// writeExtractHeader names the tsv columns
+func writeExtractHeader(out io.Writer) {
+	fmt.Fprintf(out, "file\tline\tcolumn\tscope\tkind\t%s\n", strings.Join(captureNames, "\t"))
+}
+
+// writeExtract prints a record with the named groups of every match in a
+// scope, as json lines or tab separated values
+func writeExtract(out io.Writer, name string, r *sgrep.Result) {
+	s := r.Scope
+	scope := extractScope{Start: position(name, s.Start.Line, s.Start.Col), Depth: s.Depth(), Kind: s.Kind}
+	if s.End != nil {
+		end := position(name, s.End.Line, s.End.Col)
+		scope.End = &end
+	}
+	for _, line := range r.Lines {
+		if _, ok := r.Matches[line.Num]; !ok || !inScope(s, line) {
+			continue
+		}
+		for _, re := range extractors {
+			for _, m := range re.FindAllSubmatchIndex(bytes.TrimRight(line.Text, "\r\n"), -1) {
+				if !s.Contains(line.Num, uint(m[0]), uint(m[1])) {
+					continue
+				}
+				captures := make(map[string]string)
+				for i, group := range re.SubexpNames() {
+					if group != "" && m[2*i] >= 0 {
+						captures[group] = string(line.Text[m[2*i]:m[2*i+1]])
+					}
+				}
+				pos := position(name, line, uint(m[0]))
+				if *format == "json" {
+					enc := json.NewEncoder(out)
+					enc.SetEscapeHTML(false)
+					enc.Encode(extractRecord{name, pos.Line, pos.Column, scope, captures})
+					continue
+				}
+				fmt.Fprintf(out, "%s\t%d\t%d\t%d-%s\t%s", tsvField(name), pos.Line, pos.Column,
+					scope.Start.Line, scopeEnd(scope), s.Kind)
+				for _, group := range captureNames {
+					fmt.Fprintf(out, "\t%s", tsvField(captures[group]))
+				}
+				fmt.Fprintln(out)
+			}
+		}
+	}
+}
+
+func scopeEnd(s extractScope) string {
+	if s.End == nil {
+		return "*"
+	}
+	return fmt.Sprint(s.End.Line)
+}
+
+// tsv fields can't hold tabs or line breaks, they're escaped
+var tsvEscapes = strings.NewReplacer("\\", `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func tsvField(s string) string {
+	return tsvEscapes.Replace(s)
+}
+
+// checkExtract validates the flags of --extract before searching
+func checkExtract() {
+	if err := initExtract(); err != nil {
+		fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+		os.Exit(2)
+	}
+	if *format != "json" && *format != "text" {
+		fmt.Fprintf(os.Stderr, "sgrep: --extract writes tsv, or json with --format json\n")
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// follower reads a file as it grows, like tail -f, flushing out before
+// waiting for more. A truncated file is read again from the start, one
+// rotated, replaced at its path, is read to the end before switching to
+// the new one. Pipes can't grow once they end, they're read as usual.
+type follower struct {
+	f    *os.File
+	path string   // "" for stdin, it can't be rotated
+	next *os.File // the file rotated in, once f is drained
+	out  io.Writer
+	read int64 // offset in f
+}
+
+func follow(f *os.File, path string, out io.Writer) *follower {
+	if path == "-" {
+		path = ""
+	}
+	return &follower{f: f, path: path, out: out}
+}
+
+func (r *follower) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+		if n > 0 || err != io.EOF || !r.regular() {
+			r.read += int64(n)
+			return n, err
+		}
+		if r.next != nil {
+			r.f.Close()
+			r.f, r.next, r.read = r.next, nil, 0
+			continue
+		}
+		if f, ok := r.out.(flusher); ok {
+			f.Flush()
+		}
+		if cancelled() {
+			return 0, io.EOF // the input ends here
+		}
+		time.Sleep(*watchInterval)
+		r.check()
+	}
+}
+
+// check looks for truncation and rotation
+func (r *follower) check() {
+	if info, err := r.f.Stat(); err == nil && info.Size() < r.read {
+		fmt.Fprintf(os.Stderr, "sgrep: %s: file truncated\n", r.name())
+		r.f.Seek(0, io.SeekStart)
+		r.read = 0
+		return
+	}
+	if r.path == "" {
+		return
+	}
+	info, err := os.Stat(r.path)
+	if cur, cerr := r.f.Stat(); err != nil || cerr != nil || os.SameFile(info, cur) {
+		return
+	}
+	if r.next, err = os.Open(r.path); err == nil {
+		fmt.Fprintf(os.Stderr, "sgrep: %s: file replaced, following the new one\n", r.path)
+	}
+}
+
+func (r *follower) regular() bool {
+	info, err := r.f.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+func (r *follower) name() string {
+	if r.path == "" {
+		return "(standard input)"
+	}
+	return r.path
+}
+
+// Close closes the file being read
+func (r *follower) Close() error {
+	if r.next != nil {
+		r.next.Close()
+	}
+	return r.f.Close()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a single line of a .gitignore file
+type ignoreRule struct {
+	glob     string // slash separated, relative to the ignore file's dir
+	negate   bool   // !pattern re-includes a path
+	dirOnly  bool   // pattern/ only matches directories
+	anchored bool   // pattern contains a slash, match from base dir
+}
+
+// ignoreRules holds the rules of a directory, chained to its parents'
+type ignoreRules struct {
+	dir    string
+	rules  []ignoreRule
+	parent *ignoreRules
+}
+
+var ignoreFiles = []string{".gitignore", ".ignore"}
+
+// loadIgnoreRules reads dir's ignore files, returns parent if there are none
+func loadIgnoreRules(dir string, parent *ignoreRules) *ignoreRules {
+	var rules []ignoreRule
+	for _, name := range ignoreFiles {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+				rules = append(rules, rule)
+			}
+		}
+		f.Close()
+	}
+	if len(rules) == 0 {
+		return parent
+	}
+	return &ignoreRules{dir: dir, rules: rules, parent: parent}
+}
+
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var r ignoreRule
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return r, false
+	}
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' {
+		line = line[1:] // escaped leading # or !
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimLeft(line, "/")
+	}
+	r.glob = line
+	return r, line != ""
+}
+
+// ignored checks path against the rules, closest directory and last rule win
+func (r *ignoreRules) ignored(p string, isDir bool) bool {
+	for ; r != nil; r = r.parent {
+		rel, err := filepath.Rel(r.dir, p)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for i := len(r.rules) - 1; i >= 0; i-- {
+			rule := r.rules[i]
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.matches(rel) {
+				return !rule.negate
+			}
+		}
+	}
+	return false
+}
+
+func (rule *ignoreRule) matches(rel string) bool {
+	if rule.anchored {
+		return matchGlob(rule.glob, rel)
+	}
+	return matchGlob(rule.glob, path.Base(rel))
+}
+
+// matchGlob is path.Match extended with ** matching any number of segments
+func matchGlob(glob, name string) bool {
+	return matchSegments(strings.Split(glob, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(glob, name []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(glob[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], name[0]); !ok {
+			return false
+		}
+		glob, name = glob[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// globList collects repeated --include/--exclude flags
+type globList []string
+
+func (g *globList) String() string     { return strings.Join(*g, ",") }
+func (g *globList) Set(v string) error { *g = append(*g, v); return nil }
+
+// matchAny checks the base name, or the whole path for globs with slashes
+func (g globList) matchAny(p string) bool {
+	p = filepath.ToSlash(p)
+	for _, glob := range g {
+		if strings.Contains(glob, "/") {
+			if matchGlob(strings.TrimLeft(glob, "/"), strings.TrimPrefix(p, "./")) {
+				return true
+			}
+		} else if ok, _ := path.Match(glob, path.Base(p)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// indexEntry is the cached scanning of a file kept with --index. Files
+// are scanned again when their stamp changes, or on --reindex.
+type indexEntry struct {
+	path  string // of the entry in the index directory
+	stamp stamp
+	rec   *sgrep.Recording // nil if missing or stale
+}
+
+// stored form of an entry
+type storedEntry struct {
+	Stamp struct {
+		Mod  int64
+		Size int64
+	}
+	Rec *sgrep.Recording
+}
+
+// scanKey describes the options changing how files are scanned, entries
+// found with other options don't apply
+func scanKey(lang *sgrep.Language) string {
+	name := ""
+	if lang != nil {
+		name = lang.Name
+	}
+	return fmt.Sprintf("%s|%s|%s|%v|%v|%s|%v|%v|%v|%s|%s|%v",
+		name, opts.Mode, opts.Engine, opts.Delims, opts.RegexDelims, opts.Nesting,
+		opts.NoLiterals, opts.NoEscapes, opts.CommentScopes, *recordStart, *encoding, searchZip)
+}
+
+// lookupIndex finds the entry of a file, stale ones are left for search
+// to replace
+func lookupIndex(dir, path string, info os.FileInfo, lang *sgrep.Language) *indexEntry {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(abs + "\x00" + scanKey(lang)))
+	e := &indexEntry{
+		path:  filepath.Join(dir, hex.EncodeToString(sum[:16])),
+		stamp: stamp{info.ModTime(), info.Size()},
+	}
+	if *reindex {
+		return e
+	}
+	f, err := os.Open(e.path)
+	if err != nil {
+		return e
+	}
+	defer f.Close()
+	var stored storedEntry
+	if gob.NewDecoder(f).Decode(&stored) == nil &&
+		stored.Stamp.Mod == e.stamp.mod.UnixNano() && stored.Stamp.Size == e.stamp.size {
+		e.rec = stored.Rec
+	}
+	return e
+}
+
+// save stores what was scanned, replacing the entry atomically
+func (e *indexEntry) save(rec *sgrep.Recording) {
+	if rec == nil {
+		return // the input wasn't read to the end
+	}
+	var stored storedEntry
+	stored.Stamp.Mod, stored.Stamp.Size = e.stamp.mod.UnixNano(), e.stamp.size
+	stored.Rec = rec
+	f, err := os.CreateTemp(filepath.Dir(e.path), ".entry")
+	if err != nil {
+		warn("index: %v", err)
+		return
+	}
+	err = gob.NewEncoder(f).Encode(&stored)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), e.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		warn("index: %v", err)
+	}
+}
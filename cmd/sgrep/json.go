@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// positions on json output are 1-based
+type jsonPos struct {
+	Line   uint `json:"line"`
+	Column uint `json:"column"`
+}
+
+// position of col in line, see columnOf
+func position(name string, line *sgrep.Line, col uint) jsonPos {
+	return jsonPos{line.Num + 1, columnOf(name, line.Text, col) + 1}
+}
+
+type jsonMatch struct {
+	jsonPos
+	Text string `json:"text"`
+}
+
+// jsonScope is the record written for every matched scope
+type jsonScope struct {
+	File    string      `json:"file"`
+	Start   jsonPos     `json:"start"`
+	End     *jsonPos    `json:"end"` // nil for scopes left open
+	Depth   int         `json:"depth"`
+	Kind    string      `json:"kind,omitempty"`
+	Path    string      `json:"path,omitempty"` // of the value, with --mode json, yaml or markdown
+	Crumbs  []string    `json:"breadcrumbs,omitempty"`
+	Sibs    []jsonMatch `json:"siblings,omitempty"` // opening lines of sibling scopes
+	Matches []jsonMatch `json:"matches"`
+	Body    string      `json:"body"`
+	Elided  bool        `json:"elided,omitempty"` // body lacks lines without matches
+}
+
+// writeJSON prints the scope as a single line json object
+func writeJSON(out io.Writer, name string, r *sgrep.Result) {
+	encodeJSON(out, jsonRecord(name, r))
+}
+
+func encodeJSON(out io.Writer, v interface{}) {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+// jsonRecord describes a matched scope for json output
+func jsonRecord(name string, r *sgrep.Result) *jsonScope {
+	s := r.Scope
+	rec := jsonScope{
+		File:    name,
+		Start:   position(name, s.Start.Line, s.Start.Col),
+		Depth:   s.Depth(),
+		Kind:    s.Kind,
+		Path:    s.Path,
+		Matches: []jsonMatch{},
+	}
+	rec.Elided = r.Elided()
+	if *crumbs {
+		rec.Crumbs = breadcrumbs(s)
+	}
+	if *siblings {
+		for _, sib := range s.Siblings() {
+			rec.Sibs = append(rec.Sibs, jsonMatch{
+				position(name, sib.Start.Line, sib.Start.Col),
+				string(bytes.TrimRight(sib.Start.Line.Text, "\r\n"))})
+		}
+	}
+	if s.End != nil {
+		end := position(name, s.End.Line, s.End.Col)
+		rec.End = &end
+	}
+	var body bytes.Buffer
+	for _, line := range r.Lines {
+		if !inScope(s, line) {
+			continue
+		}
+		body.Write(bytes.TrimRight(line.Text, "\r\n"))
+		body.WriteByte('\n')
+		l := line.Num
+		for _, loc := range r.Matches[l] {
+			if s.Contains(l, uint(loc[0]), uint(loc[1])) {
+				rec.Matches = append(rec.Matches, jsonMatch{
+					position(name, line, uint(loc[0])), string(line.Text[loc[0]:loc[1]])})
+			}
+		}
+	}
+	rec.Body = body.String()
+	return &rec
+}
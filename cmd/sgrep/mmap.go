@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"runtime/debug"
+)
+
+// files larger than this are mapped with --mmap auto
+const mmapThreshold = 1 << 20
+
+// lineReader reads input a line at a time, *bufio.Reader is one
+type lineReader interface {
+	ReadBytes(delim byte) ([]byte, error)
+}
+
+// mapped is a file read through a memory map. Its lines are slices of
+// the mapping, not copies, so they're only valid until it's closed.
+type mapped struct {
+	data  []byte
+	pos   int
+	unmap func()
+}
+
+// mapFile maps f as told by --mmap, nil if it's better read as usual:
+// small files with auto, pipes, or if mapping isn't supported
+func mapFile(f *os.File, info os.FileInfo) *mapped {
+	if *useMmap == "never" || info == nil || !info.Mode().IsRegular() ||
+		*useMmap == "auto" && info.Size() < mmapThreshold {
+		return nil
+	}
+	data, unmap := mmap(f, info.Size())
+	if data == nil {
+		return nil
+	}
+	return &mapped{data: data, unmap: unmap}
+}
+
+// searchMapped is searchInput over a mapping. Reading a page past the end
+// of a file truncated meanwhile, as logs are when rotated, faults. That's
+// reported as an error of the file instead of a crash with SIGBUS.
+func searchMapped(path, name string, m *mapped, info os.FileInfo, out io.Writer, printer PrinterFn) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(interface{ Addr() uintptr }); !ok {
+				panic(r)
+			}
+			warn("%s: file truncated while searching it", name)
+		}
+	}()
+	searchInput(path, name, m, info, out, printer)
+}
+
+// Read copies out of the mapping, for what sniffs the start of the input
+func (m *mapped) Read(p []byte) (int, error) {
+	if m.pos >= len(m.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[m.pos:])
+	m.pos += n
+	return n, nil
+}
+
+// lines returns a reader of the lines after what in, reading from m,
+// hasn't consumed yet
+func (m *mapped) lines(in *bufio.Reader) lineReader {
+	m.pos -= in.Buffered()
+	return m
+}
+
+// ReadBytes slices the next line out of the mapping. Its capacity ends
+// with it, appending to it can't overwrite the next one.
+func (m *mapped) ReadBytes(delim byte) ([]byte, error) {
+	if m.pos >= len(m.data) || cancelled() {
+		return nil, io.EOF
+	}
+	end := len(m.data)
+	if i := bytes.IndexByte(m.data[m.pos:], delim); i >= 0 {
+		end = m.pos + i + 1
+	}
+	line := m.data[m.pos:end:end]
+	m.pos = end
+	if end == len(m.data) {
+		return line, io.EOF
+	}
+	return line, nil
+}
+
+func (m *mapped) Close() error {
+	m.unmap()
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// mmap isn't supported, files are always read
+func mmap(f *os.File, size int64) ([]byte, func()) {
+	return nil, nil
+}
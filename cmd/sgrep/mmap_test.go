@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+func TestMappedTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	text := bytes.Repeat([]byte("2024-03-01 10:00:00 INFO request served\n"), 1<<16)
+	if err := os.WriteFile(path, text, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	m := mapFile(f, info)
+	if m == nil {
+		t.Skip("files can't be mapped")
+	}
+	defer m.Close()
+	defer func() {
+		patterns = nil
+		failed.Store(false)
+		matchedAny.Store(false)
+	}()
+	patterns = []sgrep.Matcher{compile("needle")}
+	// rotated away under the mapping
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	searchMapped(path, path, m, info, io.Discard, writePlain)
+	if !failed.Load() {
+		t.Error("searching a truncated mapping didn't fail")
+	}
+}
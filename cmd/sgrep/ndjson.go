@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// ndjson events stream what's found as it happens with --format ndjson.
+// Each input gets a begin event, a match event per matched scope and an
+// end event, a summary follows the last input. Match events carry the
+// same record as --format json.
+type ndjsonEvent struct {
+	Type string      `json:"type"` // begin, match, end or summary
+	Data interface{} `json:"data"`
+}
+
+type ndjsonFile struct {
+	File    string `json:"file"`
+	Matched *int   `json:"matched,omitempty"` // scopes, on end events
+}
+
+type ndjsonSummary struct {
+	Files   int64   `json:"files"`
+	Matched int64   `json:"matched"`
+	Elapsed float64 `json:"elapsed"` // seconds
+}
+
+var ndjsonFiles, ndjsonMatched atomic.Int64
+
+// writeEvent writes an event, flushing it out so readers get it right away
+func writeEvent(out io.Writer, kind string, data interface{}) {
+	encodeJSON(out, ndjsonEvent{kind, data})
+	if f, ok := out.(flusher); ok {
+		f.Flush()
+	}
+}
+
+func writeMatchEvent(out io.Writer, name string, r *sgrep.Result) {
+	ndjsonMatched.Add(1)
+	writeEvent(out, "match", jsonRecord(name, r))
+}
+
+func writeBeginEvent(out io.Writer, name string) {
+	ndjsonFiles.Add(1)
+	writeEvent(out, "begin", ndjsonFile{File: name})
+}
+
+func writeEndEvent(out io.Writer, name string, matched int) {
+	writeEvent(out, "end", ndjsonFile{File: name, Matched: &matched})
+}
+
+func writeSummaryEvent(out io.Writer, elapsed time.Duration) {
+	writeEvent(out, "summary", ndjsonSummary{ndjsonFiles.Load(), ndjsonMatched.Load(), elapsed.Seconds()})
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// PrinterFn writes a matched scope of the input called name
+type PrinterFn func(out io.Writer, name string, r *sgrep.Result)
+
+var (
+	colorMatch = "\033[1;31m"
+	colorDelim = "\033[1;32m"
+	colorLine  = "\033[0;33m"
+	colorName  = "\033[0;35m"
+	colorDim   = "\033[2m"
+)
+
+const colorReset = "\033[0m"
+
+// palette are the colors that can be changed in config files
+var palette = map[string]*string{
+	"match": &colorMatch,
+	"delim": &colorDelim,
+	"line":  &colorLine,
+	"name":  &colorName,
+	"dim":   &colorDim,
+}
+
+// span of a line to highlight
+type span struct {
+	start, end uint
+	color      string
+}
+
+type spans []span
+
+func (s spans) Len() int           { return len(s) }
+func (s spans) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s spans) Less(i, j int) bool { return s[i].start < s[j].start }
+
+// writePretty highlights matches and the scope's delimiters, lines without
+// matches are dimmed
+func writePretty(out io.Writer, name string, r *sgrep.Result) {
+	s := r.Scope
+	var prev *sgrep.Line
+	guides := gutters(r)
+	for _, line := range visibleLines(r) {
+		writeElision(out, r, prev, line, true)
+		prev = line
+		hl := make(spans, 0, 3)
+		base := colorDim
+		// matches go first so they win over delimiters at the same column
+		for _, loc := range r.Matches[line.Num] {
+			hl = append(hl, span{uint(loc[0]), uint(loc[1]), colorMatch})
+			base = ""
+		}
+		if line.Num == s.Start.Line.Num {
+			hl = append(hl, span{s.Start.Col, s.Start.Col + uint(len(s.Start.Delim.Str)), colorDelim})
+		}
+		if s.End != nil && line.Num == s.End.Line.Num {
+			hl = append(hl, span{s.End.Col, s.End.Col + uint(len(s.End.Delim.Str)), colorDelim})
+		}
+		sort.Stable(hl)
+		writeLineNum(out, name, line.Num, lineSep(s, line), true)
+		writeColumn(out, name, line, r.Matches[line.Num], true)
+		writeGutter(out, guides, line, true)
+		writeSpans(out, line.Text, hl, base)
+	}
+}
+
+// writeSpans writes a line in the base color with spans highlighted, see
+// writeLine
+func writeSpans(out io.Writer, text []byte, hl spans, base string) {
+	eol := len(bytes.TrimRight(text, "\r\n"))
+	pos := uint(0)
+	io.WriteString(out, base)
+	for _, h := range hl {
+		if h.start < pos || h.end > uint(eol) {
+			continue // overlapping spans
+		}
+		out.Write(text[pos:h.start])
+		io.WriteString(out, h.color)
+		out.Write(text[h.start:h.end])
+		io.WriteString(out, colorReset+base)
+		pos = h.end
+	}
+	out.Write(text[pos:eol])
+	if base != "" {
+		io.WriteString(out, colorReset)
+	}
+	io.WriteString(out, "\n")
+}
+
+// writeLine writes the text of a line ending it with \n whatever its
+// terminator, the \r of CRLF inputs would show up as ^M or garble the
+// terminal
+func writeLine(out io.Writer, text []byte) {
+	out.Write(bytes.TrimRight(text, "\r\n"))
+	io.WriteString(out, "\n")
+}
+
+func writePlain(out io.Writer, name string, r *sgrep.Result) {
+	var prev *sgrep.Line
+	guides := gutters(r)
+	for _, line := range visibleLines(r) {
+		writeElision(out, r, prev, line, false)
+		prev = line
+		writeLineNum(out, name, line.Num, lineSep(r.Scope, line), false)
+		writeColumn(out, name, line, r.Matches[line.Num], false)
+		writeGutter(out, guides, line, false)
+		writeLine(out, line.Text)
+	}
+}
+
+// writeHunks prints a scope as unified diff hunks, so editors and patch
+// tools can read them. Lines with matches show up as changed to themselves,
+// tools reject hunks without changes and they stand out. Each run of
+// contiguous lines is a hunk, its header notes where the scope starts like
+// a quickfix entry, with the column of its opening delimiter.
+func writeHunks(out io.Writer, name string, r *sgrep.Result) {
+	lines := visibleLines(r)
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", name, name)
+	for len(lines) > 0 {
+		n := 1
+		for n < len(lines) && lines[n].Num == lines[n-1].Num+1 {
+			n++
+		}
+		start := r.Scope.Start.Line
+		fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@ %s:%d:%d: %s\n", lines[0].Num+1, n, lines[0].Num+1, n,
+			name, start.Num+1, columnOf(name, start.Text, r.Scope.Start.Col)+1, bytes.TrimSpace(start.Text))
+		for _, line := range lines[:n] {
+			if _, ok := r.Matches[line.Num]; ok {
+				writeDiffLine(out, '-', line.Text)
+				writeDiffLine(out, '+', line.Text)
+			} else {
+				writeDiffLine(out, ' ', line.Text)
+			}
+		}
+		lines = lines[n:]
+	}
+}
+
+// writeGrep prints every line of a scope prefixed with the input name and
+// line number like grep -Hn, lines without matches as grep context lines.
+// With --column matching lines also get the column of the first match.
+func writeGrep(out io.Writer, name string, r *sgrep.Result) {
+	for _, line := range visibleLines(r) {
+		locs, ok := r.Matches[line.Num]
+		switch {
+		case !ok:
+			fmt.Fprintf(out, "%s-%d-", name, line.Num+1)
+		case *column:
+			fmt.Fprintf(out, "%s:%d:%d:", name, line.Num+1, columnOf(name, line.Text, uint(locs[0][0]))+1)
+		default:
+			fmt.Fprintf(out, "%s:%d:", name, line.Num+1)
+		}
+		writeLine(out, line.Text)
+	}
+}
+
+// writeOutline prints a scope and all those nested in it, one per line
+// with its line range and opening line, indented by depth. Scopes within a
+// single line add nothing to an outline, they're left out.
+func writeOutline(out io.Writer, name string, r *sgrep.Result) {
+	var walk func(s *sgrep.Scope, depth int)
+	walk = func(s *sgrep.Scope, depth int) {
+		if s.End != nil && s.End.Line == s.Start.Line {
+			return
+		}
+		if showNames {
+			fmt.Fprintf(out, "%s:", name)
+		}
+		end := "*"
+		if s.End != nil {
+			end = fmt.Sprint(s.End.Line.Num + 1)
+		}
+		fmt.Fprintf(out, "%d-%s:%s%s\n", s.Start.Line.Num+1, end,
+			strings.Repeat("  ", depth), bytes.TrimSpace(s.Start.Line.Text))
+		for _, c := range s.Childs {
+			walk(c, depth+1)
+		}
+	}
+	walk(r.Scope, 0)
+}
+
+// writeCount prints the number of matching scopes in a file, like grep -c
+func writeCount(out io.Writer, name string, n int) {
+	if showNames {
+		fmt.Fprintf(out, "%s:", name)
+	}
+	fmt.Fprintf(out, "%d\n", n)
+}
+
+// writeMatchCount prints the line range of a scope and how many matches it has
+func writeMatchCount(out io.Writer, name string, r *sgrep.Result) {
+	if showNames {
+		fmt.Fprintf(out, "%s:", name)
+	}
+	first, last := r.Scope.Start.Line, r.Lines[len(r.Lines)-1]
+	if r.Scope.End != nil {
+		last = r.Scope.End.Line
+	}
+	fmt.Fprintf(out, "%d-%d:%d\n", first.Num+1, last.Num+1, r.Scope.Total())
+}
+
+// visibleLines are the scope lines to print, with -only only the scope's
+// opening line, those with matches and context. With --headers-only just
+// the opening line, and the closing one with --closing.
+func visibleLines(r *sgrep.Result) []*sgrep.Line {
+	if *headersOnly {
+		lines := []*sgrep.Line{r.Scope.Start.Line}
+		if end := r.Scope.End; *closing && end != nil && end.Line != r.Scope.Start.Line {
+			lines = append(lines, end.Line)
+		}
+		return lines
+	}
+	if *foldDepth >= 0 && !*only {
+		return foldLines(r)
+	}
+	if !*only {
+		return r.Lines
+	}
+	var lines []*sgrep.Line
+	for _, line := range r.Lines {
+		if _, ok := r.Matches[line.Num]; ok || line == r.Scope.Start.Line || !inScope(r.Scope, line) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// foldLines collapses the scopes nested more than --fold-depth levels in
+// the result without matches. They show as their opening line up to the
+// delimiter, " ... " and their closing line from the delimiter on, like
+// `if x { ... } else { ... }` when one follows another.
+func foldLines(r *sgrep.Result) []*sgrep.Line {
+	folds := make(map[uint]*sgrep.Scope) // by opening line
+	var walk func(s *sgrep.Scope)
+	walk = func(s *sgrep.Scope) {
+		for _, c := range s.Childs {
+			if c.Depth()-r.Scope.Depth() > *foldDepth && foldable(r, c) {
+				folds[c.Start.Line.Num] = c
+			} else {
+				walk(c)
+			}
+		}
+	}
+	walk(r.Scope)
+	var lines []*sgrep.Line
+	for i := 0; i < len(r.Lines); i++ {
+		line := r.Lines[i]
+		f, ok := folds[line.Num]
+		if !ok {
+			lines = append(lines, line)
+			continue
+		}
+		text := append([]byte{}, line.Text[:f.Start.Col+uint(len(f.Start.Delim.Str))]...)
+		for {
+			end := f.End
+			text = append(text, " ... "...)
+			next, ok := folds[end.Line.Num]
+			if !ok || next == f {
+				text = append(text, end.Line.Text[end.Col:]...)
+				break
+			}
+			text = append(text, end.Line.Text[end.Col:next.Start.Col+uint(len(next.Start.Delim.Str))]...)
+			f = next
+		}
+		lines = append(lines, &sgrep.Line{Text: text, Num: line.Num})
+		for i+1 < len(r.Lines) && r.Lines[i+1].Num <= f.End.Line.Num {
+			i++
+		}
+	}
+	return lines
+}
+
+// foldable scopes span lines and have no matches on any of them
+func foldable(r *sgrep.Result, s *sgrep.Scope) bool {
+	if s.End == nil || s.End.Line == s.Start.Line {
+		return false
+	}
+	for n := s.Start.Line.Num; n <= s.End.Line.Num; n++ {
+		if _, ok := r.Matches[n]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// gutters are the --gutter prefixes of the lines of a result: the depth of
+// the innermost scope spanning each line and guides for the scopes nested
+// in the result, ├─ where they open and └─ where they close
+func gutters(r *sgrep.Result) map[uint]string {
+	if !*gutter {
+		return nil
+	}
+	depths := make(map[uint]int)
+	guides := make(map[uint]string)
+	var walk func(s *sgrep.Scope)
+	walk = func(s *sgrep.Scope) {
+		if s.End == nil || s.End.Line == s.Start.Line {
+			return
+		}
+		k := s.Depth() - r.Scope.Depth()
+		bars := strings.Repeat("│ ", k)
+		for n := s.Start.Line.Num; n <= s.End.Line.Num; n++ {
+			depths[n], guides[n] = s.Depth(), bars
+		}
+		if k > 0 {
+			bars = strings.Repeat("│ ", k-1)
+			guides[s.Start.Line.Num], guides[s.End.Line.Num] = bars+"├─", bars+"└─"
+		}
+		for _, c := range s.Childs {
+			walk(c)
+		}
+	}
+	walk(r.Scope)
+	// pad so text stays aligned
+	width := 0
+	for _, g := range guides {
+		if w := utf8.RuneCountInString(g); w > width {
+			width = w
+		}
+	}
+	for n, g := range guides {
+		guides[n] = fmt.Sprintf("%2d %s%s", depths[n], g, strings.Repeat(" ", width-utf8.RuneCountInString(g)))
+	}
+	guides[noGutter] = strings.Repeat(" ", width+3)
+	return guides
+}
+
+// noGutter is the key of the blank gutter for context lines
+const noGutter = ^uint(0)
+
+func writeGutter(out io.Writer, guides map[uint]string, line *sgrep.Line, color bool) {
+	if guides == nil {
+		return
+	}
+	g, ok := guides[line.Num]
+	if !ok {
+		g = guides[noGutter] // context lines
+	}
+	if color {
+		fmt.Fprintf(out, "%s%s%s ", colorDim, g, colorReset)
+	} else {
+		fmt.Fprintf(out, "%s ", g)
+	}
+}
+
+// inScope tells the lines of a scope apart from its context
+func inScope(s *sgrep.Scope, line *sgrep.Line) bool {
+	return line.Num >= s.Start.Line.Num && (s.End == nil || line.Num <= s.End.Line.Num)
+}
+
+// context lines are numbered with a dash, like grep
+func lineSep(s *sgrep.Scope, line *sgrep.Line) byte {
+	if inScope(s, line) {
+		return ':'
+	}
+	return '-'
+}
+
+// withBreadcrumbs precedes the output of printer with the opening lines of
+// the scopes enclosing each result
+func withBreadcrumbs(printer PrinterFn, color bool) PrinterFn {
+	sep, reset := " > ", ""
+	if color {
+		sep, reset = colorDim+sep+colorReset, colorReset
+	}
+	return func(out io.Writer, name string, r *sgrep.Result) {
+		if crumbs := breadcrumbs(r.Scope); len(crumbs) > 0 {
+			if showNames && color {
+				fmt.Fprintf(out, "%s%s%s:", colorName, name, reset)
+			} else if showNames {
+				fmt.Fprintf(out, "%s:", name)
+			}
+			fmt.Fprintln(out, strings.Join(crumbs, sep))
+		}
+		printer(out, name, r)
+	}
+}
+
+// withPaths precedes the output of printer with the path of each scope
+// within its document, scopes only have one with --mode json, yaml or markdown
+func withPaths(printer PrinterFn, color bool) PrinterFn {
+	return func(out io.Writer, name string, r *sgrep.Result) {
+		if path := r.Scope.Path; path != "" {
+			switch {
+			case showNames && color:
+				fmt.Fprintf(out, "%s%s%s:%s%s%s\n", colorName, name, colorReset, colorDim, path, colorReset)
+			case showNames:
+				fmt.Fprintf(out, "%s:%s\n", name, path)
+			case color:
+				fmt.Fprintf(out, "%s%s%s\n", colorDim, path, colorReset)
+			default:
+				fmt.Fprintln(out, path)
+			}
+		}
+		printer(out, name, r)
+	}
+}
+
+// withNull ends the output of printer for each result with a NUL byte, so
+// records with newlines can be told apart
+func withNull(printer PrinterFn) PrinterFn {
+	return func(out io.Writer, name string, r *sgrep.Result) {
+		printer(out, name, r)
+		out.Write([]byte{0})
+	}
+}
+
+// breadcrumbs are the trimmed opening lines of the scopes enclosing s
+func breadcrumbs(s *sgrep.Scope) []string {
+	var crumbs []string
+	for _, line := range s.Headers() {
+		crumbs = append(crumbs, string(bytes.TrimSpace(line.Text)))
+	}
+	return crumbs
+}
+
+// withSiblings surrounds the output of printer with the opening lines of
+// the other scopes in the same parent
+func withSiblings(printer PrinterFn, color bool) PrinterFn {
+	return func(out io.Writer, name string, r *sgrep.Result) {
+		before, after := siblingHeaders(r.Scope)
+		for _, line := range before {
+			writeSibling(out, name, line, color)
+		}
+		printer(out, name, r)
+		for _, line := range after {
+			writeSibling(out, name, line, color)
+		}
+	}
+}
+
+// siblingHeaders are the opening lines of the siblings of s before and
+// after it, each line only once and never the opening line of s
+func siblingHeaders(s *sgrep.Scope) (before, after []*sgrep.Line) {
+	seen := map[uint]bool{s.Start.Line.Num: true}
+	for _, sib := range s.Siblings() {
+		line := sib.Start.Line
+		if seen[line.Num] {
+			continue
+		}
+		seen[line.Num] = true
+		if line.Num < s.Start.Line.Num {
+			before = append(before, line)
+		} else {
+			after = append(after, line)
+		}
+	}
+	return before, after
+}
+
+func writeSibling(out io.Writer, name string, line *sgrep.Line, color bool) {
+	writeLineNum(out, name, line.Num, '-', color)
+	if color {
+		writeSpans(out, line.Text, nil, colorDim)
+	} else {
+		writeLine(out, line.Text)
+	}
+}
+
+// writeElision marks lines dropped by --max-scope-lines or --max-buffer-bytes
+// between prev and line, unless -only already skips lines
+func writeElision(out io.Writer, r *sgrep.Result, prev, line *sgrep.Line, color bool) {
+	if *only || prev == nil || line.Num == prev.Num+1 {
+		return
+	}
+	// lines folded by --fold-depth weren't dropped
+	n := sort.Search(len(r.Lines), func(i int) bool { return r.Lines[i].Num >= line.Num-1 })
+	if n < len(r.Lines) && r.Lines[n].Num == line.Num-1 {
+		return
+	}
+	if color {
+		fmt.Fprintf(out, "%s...%s\n", colorDim, colorReset)
+	} else {
+		fmt.Fprintln(out, "...")
+	}
+}
+
+// line numbers are 1-based on output, like grep -n, followed by sep
+// name is only shown when searching multiple inputs, the number unless
+// --line-number=false
+func writeLineNum(out io.Writer, name string, num uint, sep byte, color bool) {
+	if color {
+		if showNames {
+			fmt.Fprintf(out, "%s%s%s%c", colorName, name, colorReset, sep)
+		}
+		if lineNumbers {
+			fmt.Fprintf(out, "%s%d%s%c", colorLine, num+1, colorReset, sep)
+		}
+	} else {
+		if showNames {
+			fmt.Fprintf(out, "%s%c", name, sep)
+		}
+		if lineNumbers {
+			fmt.Fprintf(out, "%d%c", num+1, sep)
+		}
+	}
+}
+
+// writeColumn follows the line number of matching lines with the column of
+// their first match when asked by --column
+func writeColumn(out io.Writer, name string, line *sgrep.Line, locs [][]int, color bool) {
+	if !*column || len(locs) == 0 {
+		return
+	}
+	col := columnOf(name, line.Text, uint(locs[0][0])) + 1
+	if color {
+		fmt.Fprintf(out, "%s%d%s:", colorLine, col, colorReset)
+	} else {
+		fmt.Fprintf(out, "%d:", col)
+	}
+}
+
+// contextGroups prints results with context lines like grep does, lines
+// shared by consecutive results are printed once and groups which aren't
+// adjacent are separated
+type contextGroups struct {
+	out     io.Writer
+	name    string
+	printer PrinterFn
+	held    *sgrep.Result // printed once the next result shows its context
+	last    *sgrep.Line   // last line printed
+}
+
+func (g *contextGroups) add(r sgrep.Result) {
+	if h := g.held; h != nil {
+		// leave the lines of r out of the trailing context of the previous one
+		for n := len(h.Lines); n > 0 && !inScope(h.Scope, h.Lines[n-1]) && h.Lines[n-1].Num >= r.Lines[0].Num; n-- {
+			h.Lines = h.Lines[:n-1]
+		}
+		g.write(h)
+	}
+	g.held = &r
+}
+
+func (g *contextGroups) flush() {
+	if g.held != nil {
+		g.write(g.held)
+		g.held = nil
+	}
+}
+
+func (g *contextGroups) write(r *sgrep.Result) {
+	for len(r.Lines) > 0 && g.last != nil && r.Lines[0].Num <= g.last.Num {
+		r.Lines = r.Lines[1:]
+	}
+	if len(r.Lines) == 0 {
+		return
+	}
+	if g.last != nil && r.Lines[0].Num > g.last.Num+1 {
+		writeGroupSep(g.out, colors && *pretty)
+	}
+	g.printer(g.out, g.name, r)
+	g.last = r.Lines[len(r.Lines)-1]
+}
+
+// writeGroupSep separates results that aren't adjacent when printing context
+func writeGroupSep(out io.Writer, color bool) {
+	if color {
+		fmt.Fprintf(out, "%s--%s\n", colorDim, colorReset)
+	} else {
+		fmt.Fprintln(out, "--")
+	}
+}
+
+// useColor decides if output to f gets colors for a --color setting
+func useColor(setting string, f *os.File) (bool, error) {
+	switch setting {
+	case "always":
+		enableColors(f)
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		info, err := f.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0 && enableColors(f), nil
+	}
+	return false, fmt.Errorf("unknown color setting %q, use auto, always or never", setting)
+}
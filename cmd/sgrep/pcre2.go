@@ -0,0 +1,20 @@
+//go:build pcre2
+
+package main
+
+import (
+	"go.arsenm.dev/pcre"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// pcre2 has backreferences and lookarounds, at the price of backtracking
+func init() {
+	regexEngines["pcre2"] = func(expr string) (sgrep.Matcher, error) {
+		re, err := pcre.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return re, nil
+	}
+}
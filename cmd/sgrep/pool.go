@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// job is one input to search. Its output is buffered and written out as a
+// whole, so results from different files never interleave.
+type job struct {
+	path string // file to open, "-" for stdin
+	out  bytes.Buffer
+	done chan struct{}
+}
+
+// pool searches files on several workers, writing results in input order.
+// A single worker writes results out as they're found, flushing after each
+// input, the writer only keeps the order then.
+type pool struct {
+	work    chan *job
+	ordered chan *job
+	workers sync.WaitGroup
+	writer  sync.WaitGroup
+}
+
+func newPool(n int, out io.Writer, printer PrinterFn) *pool {
+	if n < 1 {
+		n = 1
+	}
+	p := &pool{work: make(chan *job), ordered: make(chan *job, 4*n)}
+	p.workers.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer p.workers.Done()
+			for j := range p.work {
+				if n == 1 {
+					searchFile(j.path, out, printer)
+					if f, ok := out.(flusher); ok {
+						f.Flush()
+					}
+				} else {
+					searchFile(j.path, &j.out, printer)
+				}
+				close(j.done)
+			}
+		}()
+	}
+	p.writer.Add(1)
+	go func() {
+		defer p.writer.Done()
+		for j := range p.ordered {
+			<-j.done
+			if n == 1 {
+				continue // already written by the worker
+			}
+			out.Write(j.out.Bytes())
+			// don't hold results back while waiting on slow inputs
+			if f, ok := out.(flusher); ok && len(p.ordered) == 0 {
+				f.Flush()
+			}
+		}
+	}()
+	return p
+}
+
+type flusher interface {
+	Flush() error
+}
+
+func (p *pool) add(path string) {
+	j := &job{path: path, done: make(chan struct{})}
+	p.ordered <- j
+	p.work <- j
+}
+
+// wait for all queued files to be searched and written
+func (p *pool) wait() {
+	close(p.work)
+	p.workers.Wait()
+	close(p.ordered)
+	p.writer.Wait()
+}
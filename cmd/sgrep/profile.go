@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+var cpuProfile = flag.String("cpuprofile", "", "Write a CPU profile of the search to `FILE`")
+var memProfile = flag.String("memprofile", "", "Write a heap profile to `FILE` once the search is done")
+var traceFile = flag.String("trace", "", "Write an execution trace of the search to `FILE`")
+
+// profiling stops the profiles started, writing out what's pending
+var profiling []func() error
+
+// startProfiles starts the profiles asked for, stopProfiles must run
+// before exiting for them to be complete
+func startProfiles() {
+	create := func(name string) *os.File {
+		f, err := os.Create(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+			exit(2)
+		}
+		return f
+	}
+	if *cpuProfile != "" {
+		f := create(*cpuProfile)
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fmt.Fprintf(os.Stderr, "sgrep: cpu profile: %v\n", err)
+			exit(2)
+		}
+		profiling = append(profiling, func() error {
+			pprof.StopCPUProfile()
+			return f.Close()
+		})
+	}
+	if *traceFile != "" {
+		f := create(*traceFile)
+		if err := trace.Start(f); err != nil {
+			fmt.Fprintf(os.Stderr, "sgrep: trace: %v\n", err)
+			exit(2)
+		}
+		profiling = append(profiling, func() error {
+			trace.Stop()
+			return f.Close()
+		})
+	}
+	if *memProfile != "" {
+		f := create(*memProfile)
+		profiling = append(profiling, func() error {
+			runtime.GC() // up to date statistics
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		})
+	}
+}
+
+// stopProfiles writes out the profiles, only once
+func stopProfiles() {
+	stop := profiling
+	profiling = nil
+	for _, s := range stop {
+		if err := s(); err != nil {
+			fmt.Fprintf(os.Stderr, "sgrep: profile: %v\n", err)
+		}
+	}
+}
+
+// exit stops the profiles before exiting with a status
+func exit(code int) {
+	stopProfiles()
+	os.Exit(code)
+}
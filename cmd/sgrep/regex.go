@@ -0,0 +1,19 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// regexEngines compile patterns by --regex-engine name. re2 is Go's
+// regexp, matching in linear time, others are compiled in with build tags.
+var regexEngines = map[string]func(expr string) (sgrep.Matcher, error){
+	"re2": func(expr string) (sgrep.Matcher, error) {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return re, nil
+	},
+}
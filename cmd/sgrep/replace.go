@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// replacer rewrites the matches of a pattern, only inside matched scopes
+type replacer struct {
+	re    sgrep.Matcher // compiled like the search patterns, see compile
+	with  []byte
+	write bool // edit files in place
+	diff  bool // print a unified diff, the default unless writing
+}
+
+// parseReplace reads a --replace REGEX=>REPLACEMENT argument
+func parseReplace(arg string) (*replacer, error) {
+	expr, with, ok := strings.Cut(arg, "=>")
+	if !ok {
+		return nil, fmt.Errorf("expected REGEX=>REPLACEMENT, got %q", arg)
+	}
+	return &replacer{re: compile(expr), with: []byte(with)}, nil
+}
+
+// replaceAll replaces the matches in text, regexps of the re2 engine
+// expand $1 style references to their groups
+func (rp *replacer) replaceAll(text []byte) []byte {
+	if re, ok := rp.re.(*regexp.Regexp); ok {
+		return re.ReplaceAll(text, rp.with)
+	}
+	var out []byte
+	last := 0
+	for _, loc := range rp.re.FindAllIndex(text, -1) {
+		out = append(out, text[last:loc[0]]...)
+		out = append(out, rp.with...)
+		last = loc[1]
+	}
+	return append(out, text[last:]...)
+}
+
+// file applies the replacement to an input, writing a diff or the file in
+// its encoding
+func (rp *replacer) file(path, name string, lang *sgrep.Language, in *bufio.Reader, enc textEncoding, out io.Writer) error {
+	o := opts
+	o.Language = lang
+	o.Unscoped = false // only rewrite within scopes
+	parser, err := sgrep.NewParser(o, patterns...)
+	if err != nil {
+		return err
+	}
+	var old [][]byte
+	var results []sgrep.Result
+	for {
+		line, err := in.ReadBytes('\n')
+		if len(line) > 0 {
+			old = append(old, line)
+			results = append(results, parser.Feed(line)...)
+			diagnose(name, parser)
+		}
+		if err == io.EOF {
+			results = append(results, parser.Close()...)
+			diagnose(name, parser)
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(results) > 0 {
+		matchedAny.Store(true)
+	}
+	replaced := rp.apply(old, results)
+	if rp.diff || !rp.write {
+		writeDiff(out, name, old, replaced)
+	}
+	switch {
+	case rp.write && path == "-":
+		for _, line := range enc.encode(replaced) {
+			out.Write(line)
+		}
+	case rp.write:
+		return writeLines(path, enc.encode(replaced))
+	}
+	return nil
+}
+
+// apply replaces matches within each scope, scopes further down the input
+// first so the columns of the others are still right. Scopes within others
+// reported too, as with --keep all, are left to the outer one so their
+// lines aren't replaced twice.
+func (rp *replacer) apply(old [][]byte, results []sgrep.Result) [][]byte {
+	lines := append([][]byte(nil), old...)
+	end := func(s *sgrep.Scope) (uint, uint) {
+		if s.End == nil {
+			return uint(len(lines)), 0
+		}
+		return s.End.Line.Num, s.End.Col
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i].Scope, results[j].Scope
+		if a.Start.Line.Num != b.Start.Line.Num || a.Start.Col != b.Start.Col {
+			return a.Start.Line.Num < b.Start.Line.Num || a.Start.Line.Num == b.Start.Line.Num && a.Start.Col < b.Start.Col
+		}
+		al, ac := end(a)
+		bl, bc := end(b)
+		return al > bl || al == bl && ac > bc
+	})
+	var outer []*sgrep.Scope
+	for _, r := range results {
+		s := r.Scope
+		if n := len(outer); n > 0 {
+			l, c := end(outer[n-1])
+			if el, ec := end(s); el < l || el == l && ec <= c {
+				continue
+			}
+		}
+		outer = append(outer, s)
+	}
+	for i := len(outer) - 1; i >= 0; i-- {
+		s := outer[i]
+		last := uint(len(lines) - 1)
+		if s.End != nil {
+			last = s.End.Line.Num
+		}
+		for n := s.Start.Line.Num; n <= last; n++ {
+			text := lines[n]
+			from, to := 0, len(bytes.TrimRight(text, "\r\n"))
+			if n == s.Start.Line.Num {
+				from = int(s.Start.Col)
+			}
+			if s.End != nil && n == last {
+				to = int(s.End.Col) + len(s.End.Delim.Str)
+			}
+			if from >= to {
+				continue
+			}
+			var line []byte
+			line = append(line, text[:from]...)
+			line = append(line, rp.replaceAll(text[from:to])...)
+			lines[n] = append(line, text[to:]...)
+		}
+	}
+	return lines
+}
+
+// writeLines replaces a file atomically keeping its permissions
+func writeLines(path string, lines [][]byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sgrep-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	for _, line := range lines {
+		w.Write(line)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// diffContext is how many unchanged lines surround each hunk
+const diffContext = 3
+
+// writeDiff prints a unified diff between inputs with the same number of
+// lines, as replacements are done line by line
+func writeDiff(out io.Writer, name string, old, new [][]byte) {
+	header := false
+	for start := 0; start < len(old); {
+		// find the next changed line and extend the hunk while changes
+		// are closer than twice the context
+		first := start
+		for first < len(old) && bytes.Equal(old[first], new[first]) {
+			first++
+		}
+		if first == len(old) {
+			break
+		}
+		end, gap := first, 0
+		for n := first; n < len(old) && gap <= 2*diffContext; n++ {
+			if bytes.Equal(old[n], new[n]) {
+				gap++
+			} else {
+				end, gap = n+1, 0
+			}
+		}
+		from := first - diffContext
+		if from < start {
+			from = start
+		}
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContext
+		if to > len(old) {
+			to = len(old)
+		}
+		if !header {
+			// git style prefixes for patch -p1, unless the path is absolute
+			a, b := "a/", "b/"
+			if filepath.IsAbs(name) {
+				a, b = "", ""
+			}
+			fmt.Fprintf(out, "--- %s%s\n+++ %s%s\n", a, name, b, name)
+			header = true
+		}
+		fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", from+1, to-from, from+1, to-from)
+		for n := from; n < to; n++ {
+			if bytes.Equal(old[n], new[n]) {
+				writeDiffLine(out, ' ', old[n])
+				continue
+			}
+			writeDiffLine(out, '-', old[n])
+			writeDiffLine(out, '+', new[n])
+		}
+		start = to
+	}
+}
+
+func writeDiffLine(out io.Writer, op byte, line []byte) {
+	fmt.Fprintf(out, "%c%s", op, line)
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		fmt.Fprint(out, "\n\\ No newline at end of file\n")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// replaceWrite runs --replace with --write on path, returning whether it
+// failed
+func replaceWrite(t *testing.T, path, arg, pattern string) bool {
+	var err error
+	defer func(zip bool) {
+		searchZip, replacing, patterns, opts = zip, nil, nil, sgrep.Options{}
+		failed.Store(false)
+		matchedAny.Store(false)
+	}(searchZip)
+	if replacing, err = parseReplace(arg); err != nil {
+		t.Fatal(err)
+	}
+	replacing.write = true
+	patterns, opts = []sgrep.Matcher{compile(pattern)}, sgrep.Options{Scopes: 1}
+	searchFile(path, io.Discard, writePlain)
+	return failed.Load()
+}
+
+func TestReplaceCompressed(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("void f(char *p) {\n\tfree(p);\n}\n"))
+	w.Close()
+	path := filepath.Join(t.TempDir(), "w.c.gz")
+	if err := os.WriteFile(path, gz.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	searchZip = true
+	if !replaceWrite(t, path, "free=>release", "free") {
+		t.Error("writing replacements to a compressed file didn't fail")
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, gz.Bytes()) {
+		t.Errorf("compressed file was overwritten with %q", got)
+	}
+}
+
+func TestReplaceEncoding(t *testing.T) {
+	utf16le := func(s string) []byte {
+		b := []byte{0xff, 0xfe}
+		for _, u := range utf16.Encode([]rune(s)) {
+			b = binary.LittleEndian.AppendUint16(b, u)
+		}
+		return b
+	}
+	bom := []byte{0xef, 0xbb, 0xbf}
+	tests := []struct {
+		name     string
+		in, want []byte
+	}{
+		{"utf16le.c", utf16le("void f(char *p) {\r\n\tfree(p); // ¡ñ\r\n}\r\n"),
+			utf16le("void f(char *p) {\r\n\trelease(p); // ¡ñ\r\n}\r\n")},
+		{"bom.c", append(bom, "void f(char *p) {\n\tfree(p);\n}\n"...),
+			append(bom, "void f(char *p) {\n\trelease(p);\n}\n"...)},
+	}
+	for _, tc := range tests {
+		path := filepath.Join(t.TempDir(), tc.name)
+		if err := os.WriteFile(path, tc.in, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if replaceWrite(t, path, "free=>release", "free") {
+			t.Errorf("%s: writing replacements failed", tc.name)
+		}
+		if got, _ := os.ReadFile(path); !bytes.Equal(got, tc.want) {
+			t.Errorf("%s: got % x, want % x", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReplaceBadLanguage(t *testing.T) {
+	rp, err := parseReplace("free=>release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang := &sgrep.Language{Name: "bad", Mode: "bogus"}
+	in := bufio.NewReader(strings.NewReader("f {\n  free(p);\n}\n"))
+	if err := rp.file("a.bad", "a.bad", lang, in, textEncoding{}, io.Discard); err == nil {
+		t.Error("no error replacing with a language of an unknown mode")
+	}
+}
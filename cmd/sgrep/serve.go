@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// Workspace answers queries over the files below some roots for --serve.
+// File contents stay in memory, read again only when their stamp changes,
+// so queries just need scanning scopes, not going to disk.
+type Workspace struct {
+	roots []string
+	mu    sync.Mutex
+	files map[string]*cached
+}
+
+type cached struct {
+	stamp
+	lang *sgrep.Language
+	text []byte // nil for binary files
+}
+
+// SearchArgs ask for the scopes matching Pattern below Dir, a workspace
+// root by default
+type SearchArgs struct {
+	Pattern string
+	Dir     string
+}
+
+// EnclosingArgs ask for the scopes enclosing a position, 1-based
+type EnclosingArgs struct {
+	File      string
+	Line, Col uint
+}
+
+// Search finds the scopes matching a pattern, the search options are
+// those given with --serve, -F, -i and -w included
+func (w *Workspace) Search(args *SearchArgs, reply *[]*jsonScope) error {
+	re, err := compilePattern(args.Pattern)
+	if err != nil {
+		return err
+	}
+	roots := w.roots
+	if args.Dir != "" {
+		if !w.inside(args.Dir) {
+			return fmt.Errorf("%s: outside of the served directories", args.Dir)
+		}
+		roots = []string{args.Dir}
+	}
+	*reply = []*jsonScope{}
+	for _, root := range roots {
+		files.walk(root, func(path string) {
+			// symlinks may lead out of the roots
+			if w.inside(path) {
+				*reply = append(*reply, w.query(path, opts, re)...)
+			}
+		})
+	}
+	return nil
+}
+
+// Enclosing finds the scopes around a position of a file, like --at
+func (w *Workspace) Enclosing(args *EnclosingArgs, reply *[]*jsonScope) error {
+	if args.Line == 0 {
+		return fmt.Errorf("lines start at 1")
+	}
+	if !w.inside(args.File) {
+		return fmt.Errorf("%s: outside of the served directories", args.File)
+	}
+	o := opts
+	o.AtLine, o.AtCol = args.Line, args.Col
+	*reply = w.query(args.File, o)
+	return nil
+}
+
+// inside checks a path is within one of the roots once symlinks are
+// resolved, clients only get to read what was served
+func (w *Workspace) inside(path string) bool {
+	real, err := realPath(path)
+	if err != nil {
+		return false
+	}
+	for _, root := range w.roots {
+		rel, err := filepath.Rel(root, real)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// realPath is the absolute path of a file with symlinks resolved
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// query runs a parser over a file, nil if it can't be read
+func (w *Workspace) query(path string, o sgrep.Options, patterns ...sgrep.Matcher) []*jsonScope {
+	f := w.load(path)
+	if f == nil || f.text == nil {
+		return nil
+	}
+	o.Language = f.lang
+	parser, err := sgrep.NewParser(o, patterns...)
+	if err != nil {
+		return nil
+	}
+	var results []sgrep.Result
+	for text := f.text; len(text) > 0; {
+		n := bytes.IndexByte(text, '\n') + 1
+		if n == 0 {
+			n = len(text)
+		}
+		results = append(results, parser.Feed(text[:n:n])...)
+		text = text[n:]
+	}
+	results = append(results, parser.Close()...)
+	records := make([]*jsonScope, len(results))
+	for i := range results {
+		records[i] = jsonRecord(path, &results[i])
+	}
+	return records
+}
+
+// load returns a file from memory, reading it if it changed
+func (w *Workspace) load(path string) *cached {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	now := stamp{info.ModTime(), info.Size()}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if f, ok := w.files[path]; ok && f.mod.Equal(now.mod) && f.size == now.size {
+		return f
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer in.Close()
+	r, _, err := decode(path, bufio.NewReader(in), *encoding)
+	if err != nil {
+		return nil
+	}
+	f := &cached{stamp: now, lang: lang}
+	if f.lang == nil {
+		f.lang = sgrep.DetectLanguage(path)
+	}
+	if !isBinary(r) {
+		var text bytes.Buffer
+		if _, err := text.ReadFrom(r); err != nil {
+			return nil
+		}
+		f.text = text.Bytes()
+	}
+	w.files[path] = f
+	return f
+}
+
+// serve answers JSON-RPC queries on a unix socket, or tcp if addr has a
+// port, until interrupted. Methods are sgrep.Search and sgrep.Enclosing.
+// Without a host only localhost is listened on, clients read the files
+// below the roots.
+func serve(addr string, roots []string) error {
+	var served []string
+	for _, root := range roots {
+		real, err := realPath(root)
+		if err != nil {
+			return err
+		}
+		served = append(served, real)
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("sgrep", &Workspace{roots: served, files: make(map[string]*cached)}); err != nil {
+		return err
+	}
+	network := "unix"
+	// paths have separators, C:\x on windows would split as a host and port
+	if host, port, err := net.SplitHostPort(addr); err == nil && !strings.ContainsAny(addr, `/\`) {
+		network = "tcp"
+		if host == "" {
+			addr = net.JoinHostPort("localhost", port)
+		}
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	// closing removes unix sockets
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	go func() {
+		<-interrupted
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
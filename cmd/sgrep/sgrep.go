@@ -0,0 +1,842 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+var ancestor = flag.Uint("ancestor", 0, "Output only the `N`-th enclosing scope of matches, 1 the tightest, instead of --scopes")
+var pretty = flag.Bool("pretty", true, "Use colors, see --color")
+var color = flag.String("color", "auto", "Colorize output: auto (only on terminals), always or never")
+var headersOnly = flag.Bool("headers-only", false, "Print only the opening line of each scope, an index of where matches are")
+var closing = flag.Bool("closing", false, "With --headers-only, also print the closing line of each scope")
+var foldDepth = flag.Int("fold-depth", -1, "Collapse scopes nested more than `K` levels in those printed to one line, unless they have matches")
+var gutter = flag.Bool("gutter", false, "Precede lines with their nesting depth and guides showing the scopes within those printed")
+var keep = flag.String("keep", "broadest", "Of the nested scopes --scopes marks for a match print the broadest, the tightest or all")
+var only = flag.Bool("only", false, "Print only the matching lines of a scope, after its opening line")
+var count = flag.Bool("c", false, "Print the number of matching scopes per file instead")
+var countMatches = flag.Bool("count-matches", false, "Print the number of matches in each matching scope instead")
+var listFiles = flag.Bool("l", false, "Print only the names of files with matching scopes")
+var listNonMatching = flag.Bool("L", false, "Print only the names of files without matching scopes")
+var literals = flag.Bool("literals", true, "Ignore delimiters inside string literals")
+var codeOnly = flag.Bool("code-only", false, "Only match outside of comments and string literals")
+var commentsOnly = flag.Bool("comments-only", false, "Only match inside comments")
+var stringsOnly = flag.Bool("strings-only", false, "Only match inside string literals")
+var matchOn = flag.String("match-on", "anywhere", "Where matches count: header (the opening line of scopes), body or anywhere")
+var extract = flag.Bool("extract", false, "Print the named groups of every match with their scope, as tsv or with --format json")
+var multiline = flag.Bool("multiline", false, "Match patterns across the lines of each top level scope, (?s) lets . match newlines")
+var escapes = flag.Bool("escapes", true, "Ignore delimiters escaped with a backslash, in languages with escapes")
+var langName = flag.String("lang", "", "Language of the input, guessed from file extensions by default")
+var commentScopes = flag.Bool("comment-scopes", false, "Treat block comments as scopes too")
+var mode = flag.String("mode", "", "How scopes are defined: "+strings.Join(sgrep.Modes, ", ")+" (default depends on language)")
+var engine = flag.String("engine", "heuristic", "Parser finding scopes: "+strings.Join(sgrep.Engines, ", "))
+var jobs = flag.Int("j", runtime.NumCPU(), "Number of files to search in parallel")
+var format = flag.String("format", "text", "Output format: text, json, ndjson, diff or grep")
+var fixed = flag.Bool("F", false, "Patterns are fixed strings, not regular expressions")
+var regexEngine = flag.String("regex-engine", "re2", "Regular expression engine: re2, or pcre2 when built with the pcre2 tag")
+var icase = flag.Bool("i", false, "Ignore case distinctions in patterns")
+var word = flag.Bool("w", false, "Only match whole words")
+var all = flag.Bool("all", false, "With several -e patterns, only print scopes matching all of them")
+var nesting = flag.String("nesting", "nested", "With --begin, if scopes of a pair nest or a begin within one is ignored: "+strings.Join(sgrep.Nestings, " or "))
+var recordStart = flag.String("record-start", "", "With --mode logs, lines matching `REGEX` start records (default timestamps)")
+var from = flag.String("from", "", "Only count matches after a line matching `REGEX` in the same scope or one enclosing it")
+var until = flag.String("until", "", "With --from, stop counting matches at a line matching `REGEX` in the window's scope")
+var head = flag.String("scope", "", "Only print scopes whose opening line matches `PATTERN`")
+var crumbs = flag.Bool("breadcrumbs", false, "Precede scopes with the opening lines of their enclosing scopes")
+var maxLines = flag.Int("max-scope-lines", 0, "Elide lines without matches from open scopes past `N` lines (0 unlimited)")
+var maxBytes = flag.Int("max-buffer-bytes", 0, "Elide lines without matches from open scopes past `N` bytes (0 unlimited)")
+var output string
+var outInfo os.FileInfo // of --output or stdout, so it isn't searched
+var after = flag.Uint("A", 0, "Print `N` lines of context after each scope")
+var before = flag.Uint("B", 0, "Print `N` lines of context before each scope")
+var context = flag.Uint("C", 0, "Print `N` lines of context around each scope")
+var depth = flag.Int("depth", -1, "Only print scopes nested `N` levels deep, top level is 0")
+var minDepth = flag.Int("min-depth", 0, "Only print scopes nested at least `N` levels deep")
+var maxDepth = flag.Int("max-depth", -1, "Only print scopes nested at most `N` levels deep")
+var maxNesting = flag.Int("max-depth-hard", 0, "Take delimiters nested past `N` levels as text, guarding against pathological input (0 unlimited)")
+var requireScope = flag.Bool("require-scope", false, "Drop matches out of any scope, instead of printing their line")
+var query = flag.String("query", "", "Only print scopes satisfying `EXPR`, ie: 'kind=function AND contains(/TODO/) AND depth<=2', patterns then only come from -e")
+var minSpan = flag.Int("min-lines", 0, "Only print scopes spanning at least `N` lines")
+var maxSpan = flag.Int("max-lines", 0, "Only print scopes spanning at most `N` lines (0 unlimited)")
+var siblings = flag.Bool("siblings", false, "Also print the opening lines of the other scopes in the same parent")
+var kind = flag.String("kind", "", "Only print scopes of a kind: "+strings.Join(sgrep.KindNames, ", "))
+var replace = flag.String("replace", "", "Rewrite `REGEX=>REPLACEMENT` inside matched scopes, printing a diff")
+var write = flag.Bool("write", false, "With --replace, edit files in place")
+var diff = flag.Bool("diff", false, "With --replace --write, still print the diff")
+var replacing *replacer // from --replace
+var column = flag.Bool("column", false, "Print the column of the first match on matching lines")
+var columnMode = flag.String("column-mode", "bytes", "Count columns in bytes, runes or visual (runes with tabs expanded)")
+var tabWidth = flag.Uint("tab-width", 8, "Tab stops for --column-mode visual every `N` columns")
+var maxCount = flag.Int("m", 0, "Stop reading a file after `N` matching scopes")
+var maxTotal = flag.Int64("max-total", 0, "Stop after `N` matching scopes across all files, implies -j 1")
+var total atomic.Int64 // matching scopes so far, for --max-total
+var useMmap = flag.String("mmap", "auto", "Read regular files through memory maps: auto (those over 1MiB), always or never")
+var binaryPolicy = flag.String("binary", "report", "Binary files: report if they match, skip them or search them as text")
+var strict = flag.Bool("strict", false, "Report unpaired delimiters as errors")
+var at = flag.String("at", "", "Print the scopes enclosing `LINE[:COL]` instead of searching, no pattern needed")
+var listScopes = flag.Bool("list-scopes", false, "Print the scope tree of the inputs as an outline, no pattern needed")
+var browse = flag.Bool("tui", false, "Browse the matched scopes on an interactive terminal UI")
+var ui *tui // from --tui
+var watching = flag.Bool("watch", false, "Search again every time the inputs change, until interrupted")
+var watchInterval = flag.Duration("watch-interval", 300*time.Millisecond, "How often --watch and --follow look for changes")
+var clearScreen = flag.Bool("clear", false, "With --watch, clear the screen before searching again")
+var serveAddr = flag.String("serve", "", "Answer JSON-RPC queries on the unix socket or host:port `ADDR` over the inputs, instead of searching (:port listens on localhost only)")
+var indexDir = flag.String("index", "", "Cache the scopes of files in `DIR`, searching again only runs patterns on unchanged files")
+var reindex = flag.Bool("reindex", false, "With --index, scan files again rebuilding their entries, no pattern needed")
+var timeout = flag.Duration("timeout", 0, "Stop searching after `DURATION`, still printing the scopes found (0 never)")
+var dedupe = flag.Bool("dedupe", false, "Print scopes the same but for whitespace once, then the files each was seen in")
+var showStats = flag.Bool("stats", false, "Print statistics on the inputs searched to stderr when done")
+var searchZip bool
+var following bool
+var archives = flag.Bool("archive", false, "Search the files inside tar, tar.gz and zip archives, named ARCHIVE!MEMBER")
+var encoding = flag.String("encoding", "auto", "Input encoding: "+strings.Join(encodings, ", ")+" (auto reads byte order marks)")
+var nscopes uint     // from --scopes, or -n as before it had a long form
+var lineNumbers bool // from --line-number, -N turns them off
+var recursive bool
+var quiet bool
+var null bool // end records with NUL
+var invert bool
+var showNames bool // prefix output with file names
+var files walker
+var exprs patternList // from -e, when given all arguments are files
+var patterns []sgrep.Matcher
+var args []string            // input files
+var lang *sgrep.Language     // forced by --lang, detected per file if nil
+var delimPairs delimList     // from --delim, replacing the language ones
+var begins, ends patternList // from --begin and --end, paired in order
+var opts sgrep.Options
+var colors bool // decided from --color and the output
+
+// exit status like grep: 0 if any scope matched, 1 if none, 2 on errors
+var matchedAny, failed atomic.Bool
+
+func init() {
+	flag.UintVar(&nscopes, "scopes", 1, "Number of outer scopes to output")
+	flag.UintVar(&nscopes, "n", 1, "Same as --scopes, unlike grep -n which --line-number is")
+	flag.BoolVar(&lineNumbers, "line-number", true, "Precede lines with their line number, --line-number=false to leave them out")
+	flag.Var(negated{&lineNumbers}, "N", "Leave out line numbers")
+	flag.Var(negated{&lineNumbers}, "no-line-number", "Leave out line numbers")
+	flag.BoolVar(&searchZip, "z", false, "Search inside gzip, bzip2, zstd and xz compressed files")
+	flag.BoolVar(&searchZip, "search-zip", false, "Search inside gzip, bzip2, zstd and xz compressed files")
+	flag.BoolVar(&following, "f", false, "Keep reading the input as it grows, like tail -f, until interrupted")
+	flag.BoolVar(&following, "follow", false, "Keep reading the input as it grows, like tail -f, until interrupted")
+	flag.BoolVar(&recursive, "r", false, "Search directories recursively")
+	flag.BoolVar(&recursive, "recursive", false, "Search directories recursively")
+	flag.Var(&files.include, "include", "Only search files matching `GLOB` (repeatable)")
+	flag.Var(&files.exclude, "exclude", "Skip files and directories matching `GLOB` (repeatable)")
+	flag.BoolVar(&files.gitignore, "gitignore", false, "Honor .gitignore and .ignore files")
+	flag.StringVar(&output, "o", "", "Write results to `FILE` instead of stdout")
+	flag.StringVar(&output, "output", "", "Write results to `FILE` instead of stdout")
+	flag.BoolVar(&quiet, "q", false, "Print nothing, exit with status 0 on the first match")
+	flag.BoolVar(&quiet, "quiet", false, "Print nothing, exit with status 0 on the first match")
+	flag.BoolVar(&null, "0", false, "End file names and scope records with a NUL byte, for xargs -0")
+	flag.BoolVar(&null, "null", false, "End file names and scope records with a NUL byte, for xargs -0")
+	flag.BoolVar(&invert, "v", false, "Print the scopes without matches")
+	flag.BoolVar(&invert, "invert-scope", false, "Print the scopes without matches")
+	flag.Var(&delimPairs, "delim", "Scope delimiters as `OPEN:CLOSE`, ie: begin:end (repeatable)")
+	flag.Var(&begins, "begin", "Scopes open at matches of `REGEX`, paired in order with --end (repeatable)")
+	flag.Var(&ends, "end", "Scopes close at matches of `REGEX`, see --begin (repeatable)")
+	flag.Var(&exprs, "e", "Search for `PATTERN`, repeat to search for several")
+	flag.Var(&exprs, "regexp", "Search for `PATTERN`, repeat to search for several")
+	// long names of the options from grep
+	flag.BoolVar(icase, "ignore-case", false, "Ignore case distinctions in patterns")
+	flag.BoolVar(word, "word-regexp", false, "Only match whole words")
+	flag.BoolVar(fixed, "fixed-strings", false, "Patterns are fixed strings, not regular expressions")
+	flag.BoolVar(count, "count", false, "Print the number of matching scopes per file instead")
+	flag.BoolVar(listFiles, "files-with-matches", false, "Print only the names of files with matching scopes")
+	flag.BoolVar(listNonMatching, "files-without-match", false, "Print only the names of files without matching scopes")
+	flag.IntVar(maxCount, "max-count", 0, "Stop reading a file after `N` matching scopes")
+	flag.UintVar(after, "after-context", 0, "Print `N` lines of context after each scope")
+	flag.UintVar(before, "before-context", 0, "Print `N` lines of context before each scope")
+	flag.UintVar(context, "context", 0, "Print `N` lines of context around each scope")
+	flag.IntVar(jobs, "jobs", runtime.NumCPU(), "Number of files to search in parallel")
+}
+
+// parseFlags reads the config files and the command line, exiting on
+// errors. It's left to main so tests don't parse theirs.
+func parseFlags() {
+	err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+		os.Exit(2)
+	}
+	if args, err = parseArgs(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "sgrep: %v\n%s\nTry 'sgrep --help' for more information.\n", err, usageLine)
+		os.Exit(2)
+	}
+	if len(exprs) == 0 && !*listScopes && *at == "" && *serveAddr == "" && !*reindex && *query == "" {
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "%s\nTry 'sgrep --help' for more information.\n", usageLine)
+			os.Exit(2)
+		}
+		exprs, args = patternList{args[0]}, args[1:]
+	}
+	for _, e := range exprs {
+		patterns = append(patterns, compile(e))
+	}
+	if *langName != "" {
+		if lang, err = sgrep.LookupLanguage(*langName); err != nil {
+			fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	opts = sgrep.Options{
+		Scopes:        nscopes,
+		Ancestor:      *ancestor,
+		Mode:          *mode,
+		Engine:        *engine,
+		Kind:          *kind,
+		Delims:        delimPairs,
+		Nesting:       *nesting,
+		NoLiterals:    !*literals,
+		NoEscapes:     !*escapes,
+		MatchOn:       *matchOn,
+		Multiline:     *multiline,
+		CommentScopes: *commentScopes,
+		Invert:        invert,
+		All:           *all,
+		MaxLines:      *maxLines,
+		MaxBytes:      *maxBytes,
+		Before:        *before,
+		After:         *after,
+		Strict:        *strict,
+		Outline:       *listScopes,
+		Keep:          *keep,
+		MaxNesting:    *maxNesting,
+		Unscoped:      !*requireScope,
+		MinSpan:       *minSpan,
+		MaxSpan:       *maxSpan,
+	}
+	for region, only := range map[string]bool{"code": *codeOnly, "comments": *commentsOnly, "strings": *stringsOnly} {
+		if only && opts.Region != "" {
+			fmt.Fprintln(os.Stderr, "sgrep: only one of --code-only, --comments-only and --strings-only")
+			os.Exit(2)
+		} else if only {
+			opts.Region = region
+		}
+	}
+	if *depth >= 0 {
+		*minDepth, *maxDepth = *depth, *depth
+	}
+	opts.MinDepth, opts.DepthLimit = *minDepth, *maxDepth+1
+	if *before == 0 {
+		opts.Before = *context
+	}
+	if *after == 0 {
+		opts.After = *context
+	}
+	if *head != "" {
+		opts.Head = compile(*head)
+	}
+	if *query != "" {
+		if opts.Query, err = sgrep.ParseQuery(*query); err != nil {
+			fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *until != "" && *from == "" {
+		fmt.Fprintln(os.Stderr, "sgrep: --until needs --from")
+		os.Exit(2)
+	}
+	if *from != "" {
+		opts.From = compile(*from)
+	}
+	if *until != "" {
+		opts.Until = compile(*until)
+	}
+	if *at != "" {
+		line, col, _ := strings.Cut(*at, ":")
+		n, err := strconv.ParseUint(line, 10, 0)
+		c, cerr := uint64(0), error(nil)
+		if col != "" {
+			c, cerr = strconv.ParseUint(col, 10, 0)
+		}
+		if err != nil || cerr != nil || n == 0 {
+			fmt.Fprintf(os.Stderr, "sgrep: bad --at %q, expected LINE[:COL]\n", *at)
+			os.Exit(2)
+		}
+		opts.AtLine, opts.AtCol = uint(n), uint(c)
+	}
+	if len(begins) != len(ends) {
+		fmt.Fprintln(os.Stderr, "sgrep: every --begin needs an --end")
+		os.Exit(2)
+	}
+	for i := range begins {
+		opts.RegexDelims = append(opts.RegexDelims, [2]string{begins[i], ends[i]})
+	}
+	if *recordStart != "" {
+		opts.RecordStart = compileRegex(*recordStart)
+		if opts.Mode == "" {
+			opts.Mode = "logs"
+		}
+	}
+	if *replace != "" {
+		if replacing, err = parseReplace(*replace); err != nil {
+			fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+			os.Exit(2)
+		}
+		replacing.write, replacing.diff = *write, *diff
+	}
+	if _, err := sgrep.NewParser(opts, patterns...); err != nil {
+		fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+		os.Exit(2)
+	}
+}
+
+// delimList collects repeated --delim flags
+type delimList [][2]string
+
+func (d *delimList) String() string {
+	pairs := make([]string, 0, len(*d))
+	for _, p := range *d {
+		pairs = append(pairs, p[0]+":"+p[1])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (d *delimList) Set(v string) error {
+	open, close, ok := strings.Cut(v, ":")
+	if !ok || open == "" || close == "" {
+		return fmt.Errorf("expected OPEN:CLOSE, got %q", v)
+	}
+	if open == close {
+		return fmt.Errorf("open and close delimiters must differ: %q", v)
+	}
+	*d = append(*d, [2]string{open, close})
+	return nil
+}
+
+// negated is a boolean flag setting the opposite of its value, like
+// --no-line-number for --line-number=false
+type negated struct{ b *bool }
+
+func (n negated) IsBoolFlag() bool { return true }
+
+func (n negated) String() string {
+	if n.b == nil {
+		return "false"
+	}
+	return strconv.FormatBool(!*n.b)
+}
+
+func (n negated) Set(v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	*n.b = !b
+	return nil
+}
+
+// compile a pattern from the command line honoring -F, -i and -w
+func compile(expr string) sgrep.Matcher {
+	m, err := compilePattern(expr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+		os.Exit(2)
+	}
+	return m
+}
+
+// compilePattern is compile returning errors instead of exiting
+func compilePattern(expr string) (sgrep.Matcher, error) {
+	if *fixed && !*icase {
+		if *word {
+			return sgrep.Words(sgrep.Literal(expr)), nil
+		}
+		return sgrep.Literal(expr), nil
+	}
+	if *fixed {
+		// case folding is left to the regexp engine, it knows unicode
+		expr = regexp.QuoteMeta(expr)
+	}
+	if *word {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if *icase {
+		expr = "(?i)" + expr
+	}
+	return compileEngine(expr)
+}
+
+// compileRegex compiles a regular expression with the --regex-engine
+func compileRegex(expr string) sgrep.Matcher {
+	m, err := compileEngine(expr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+		os.Exit(2)
+	}
+	return m
+}
+
+func compileEngine(expr string) (sgrep.Matcher, error) {
+	engine, ok := regexEngines[*regexEngine]
+	if !ok {
+		return nil, fmt.Errorf("unknown regex engine %q", *regexEngine)
+	}
+	return engine(expr)
+}
+
+// patternList collects repeated -e flags
+type patternList []string
+
+func (p *patternList) String() string { return strings.Join(*p, ",") }
+
+func (p *patternList) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
+// search a single input, printing matching scopes as they close
+func search(name string, lang *sgrep.Language, in lineReader, out io.Writer, printer PrinterFn, idx *indexEntry) (int, error) {
+	o := opts
+	o.Language = lang
+	if idx != nil {
+		o.Replay, o.Record = idx.rec, idx.rec == nil
+	}
+	// the language of a file may not go with the options of all of them
+	parser, err := sgrep.NewParser(o, patterns...)
+	if err != nil {
+		return 0, err
+	}
+	if *showStats {
+		start := time.Now()
+		defer func() { report.add(name, parser.Stats(), time.Since(start)) }()
+	}
+	matched := 0
+	silent := quiet || *count || *listFiles || *listNonMatching
+	if *format == "ndjson" && !silent {
+		writeBeginEvent(out, name)
+		defer func() { writeEndEvent(out, name, matched) }()
+	}
+	var groups *contextGroups
+	if (opts.Before > 0 || opts.After > 0) && *format == "text" && !*countMatches {
+		groups = &contextGroups{out: out, name: name, printer: printer}
+		defer groups.flush()
+	}
+	emit := func(results []sgrep.Result) {
+		if *maxCount > 0 && len(results) > *maxCount-matched {
+			results = results[:*maxCount-matched]
+		}
+		if *maxTotal > 0 {
+			if left := *maxTotal - total.Load(); int64(len(results)) > left {
+				results = results[:left]
+			}
+			total.Add(int64(len(results)))
+		}
+		matched += len(results)
+		if quiet && matched > 0 {
+			exit(0)
+		}
+		if silent {
+			return
+		}
+		for _, r := range results {
+			if groups != nil {
+				groups.add(r)
+			} else {
+				printer(out, name, &r)
+			}
+		}
+	}
+	for {
+		// the parser keeps lines around, each needs its own copy or a
+		// slice of a mapping outliving it
+		line, err := in.ReadBytes('\n')
+		if len(line) > 0 {
+			if ui != nil {
+				ui.keep(name, line)
+			}
+			emit(parser.Feed(line))
+			diagnose(name, parser)
+		}
+		if err == io.EOF {
+			emit(parser.Close())
+			diagnose(name, parser)
+			if o.Record {
+				idx.save(parser.Recording())
+			}
+			break
+		} else if err != nil {
+			return matched, err
+		}
+		// listing files only needs to know if there's any match
+		if matched > 0 && (*listFiles || *listNonMatching) ||
+			*maxCount > 0 && matched >= *maxCount || exhausted() {
+			break
+		}
+	}
+	if matched > 0 {
+		matchedAny.Store(true)
+	} else if ui != nil {
+		ui.drop(name)
+	}
+	switch {
+	case quiet:
+	case *count:
+		writeCount(out, name, matched)
+	case *listFiles && matched > 0, *listNonMatching && matched == 0:
+		if null {
+			fmt.Fprintf(out, "%s\x00", name)
+		} else {
+			fmt.Fprintln(out, name)
+		}
+	}
+	return matched, nil
+}
+
+// exhausted tells if --max-total scopes were found already
+func exhausted() bool {
+	return *maxTotal > 0 && total.Load() >= *maxTotal
+}
+
+// search a file from disk, or stdin for "-". Binary files are handled as
+// told by --binary, errors are reported and don't stop searching other files
+func searchFile(path string, out io.Writer, printer PrinterFn) {
+	if exhausted() || cancelled() {
+		return
+	}
+	if *archives && path != "-" && isArchive(path) {
+		searchArchive(path, out, printer)
+		return
+	}
+	name, f := path, os.Stdin
+	var info os.FileInfo
+	if path == "-" {
+		name = "(standard input)"
+	} else {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			warn("%v", err)
+			return
+		}
+		defer f.Close()
+		if info, err = f.Stat(); err == nil && outInfo != nil && os.SameFile(info, outInfo) {
+			warn("%v: input file is also the output", path)
+			return
+		}
+	}
+	st, err := f.Stat()
+	blocking := err != nil || !st.Mode().IsRegular()
+	// results keep their lines for the tui, mapped ones would be gone
+	if m := mapFile(f, info); m != nil && !following && ui == nil {
+		defer m.Close()
+		searchMapped(path, name, m, info, out, printer)
+		return
+	}
+	if following {
+		r := follow(f, path, out)
+		defer r.Close()
+		searchInput(path, name, stoppable(r, blocking), info, out, printer)
+		return
+	}
+	searchInput(path, name, stoppable(f, blocking), info, out, printer)
+}
+
+// searchInput searches an open input, path is "-" for stdin or the file
+// its language is detected from, info is nil if it isn't a file on disk
+func searchInput(path, name string, f io.Reader, info os.FileInfo, out io.Writer, printer PrinterFn) {
+	in := bufio.NewReader(f)
+	sniffed := in
+	if searchZip {
+		var done func() error
+		var err error
+		if in, done, err = decompress(in); err != nil {
+			warn("%s: %v", name, err)
+			return
+		}
+		defer func() {
+			if err := done(); err != nil {
+				warn("%s: %v", name, err)
+			}
+		}()
+		// the replaced text would be written uncompressed over the file
+		if in != sniffed && replacing != nil && replacing.write {
+			warn("%s: can't write replacements to compressed files", name)
+			return
+		}
+	}
+	in, enc, err := decode(name, in, *encoding)
+	if err != nil {
+		warn("%v", err)
+		return
+	}
+	// peeking for binary data would wait for more of a followed input
+	binary := *binaryPolicy != "text" && !following && isBinary(in)
+	if binary && (*binaryPolicy == "skip" || replacing != nil) {
+		return
+	}
+	l := lang
+	if l == nil && path != "-" {
+		l = sgrep.DetectLanguage(uncompressedName(path))
+	}
+	var idx *indexEntry
+	if *indexDir != "" && info != nil && replacing == nil {
+		idx = lookupIndex(*indexDir, path, info, l)
+	}
+	var lines lineReader = in
+	if m, ok := f.(*mapped); ok && in == sniffed {
+		// neither compressed nor transcoded, lines can be the mapping's
+		lines = m.lines(in)
+	}
+	run := func() error {
+		_, err := search(name, l, lines, out, printer, idx)
+		return err
+	}
+	switch {
+	case replacing != nil:
+		run = func() error { return replacing.file(path, name, l, in, enc, out) }
+	case binary && !(quiet || *count || *listFiles || *listNonMatching):
+		// scopes of binary data are garbage, only tell if there are matches
+		run = func() error {
+			n, err := search(name, l, lines, io.Discard, printer, idx)
+			if n > 0 {
+				fmt.Fprintf(out, "Binary file %s matches\n", name)
+			}
+			return err
+		}
+	}
+	if err := run(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			err = fmt.Errorf("%s: %v", name, err)
+		}
+		warn("%v", err)
+	}
+}
+
+// searchAll searches the inputs, walking directories with -r
+func searchAll(inputs []string, out io.Writer, printer PrinterFn) {
+	if *showStats {
+		start := time.Now()
+		defer func() { report.write(os.Stderr, time.Since(start)) }()
+	}
+	if *format == "ndjson" {
+		start := time.Now()
+		defer func() { writeSummaryEvent(out, time.Since(start)) }()
+	}
+	if *dedupe && *format == "text" && ui == nil {
+		defer printed.write(out)
+	}
+	workers := newPool(*jobs, out, printer)
+	for _, file := range inputs {
+		if file == "-" {
+			workers.add(file)
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && info.IsDir() {
+			if !recursive {
+				warn("%v: Is a directory", file)
+				continue
+			}
+			files.walk(file, workers.add)
+			continue
+		}
+		workers.add(file)
+	}
+	workers.wait()
+}
+
+func stdin(inputs []string) bool {
+	for _, input := range inputs {
+		if input == "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// warn reports a problem with some input, searching goes on
+func warn(format string, args ...interface{}) {
+	failed.Store(true)
+	fmt.Fprintf(os.Stderr, "sgrep: "+format+"\n", args...)
+}
+
+// diagnose reports the unpaired delimiters found with --strict
+func diagnose(name string, parser *sgrep.Parser) {
+	for _, p := range parser.Problems() {
+		m := p.Marker
+		warn("%s:%d:%d: %s", name, m.Line.Num+1, columnOf(name, m.Line.Text, m.Col)+1, p.Msg)
+	}
+}
+
+// look for NUL bytes in the first block of input, like grep does
+func isBinary(in *bufio.Reader) bool {
+	head, _ := in.Peek(1024)
+	return bytes.IndexByte(head, 0) != -1
+}
+
+func main() {
+	parseFlags()
+	var err error
+	out := os.Stdout
+	if output != "" {
+		if out, err = os.Create(output); err != nil {
+			fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	// stdout may be redirected to a file below the inputs too
+	outInfo, _ = out.Stat()
+	colors, err = useColor(*color, out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+		os.Exit(2)
+	}
+	if *reindex && *indexDir == "" {
+		fmt.Fprintln(os.Stderr, "sgrep: --reindex needs --index")
+		os.Exit(2)
+	}
+	if *indexDir != "" {
+		if err := os.MkdirAll(*indexDir, 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	switch *binaryPolicy {
+	case "report", "skip", "text":
+	default:
+		fmt.Fprintf(os.Stderr, "sgrep: unknown binary policy %q\n", *binaryPolicy)
+		os.Exit(2)
+	}
+	switch *useMmap {
+	case "auto", "always", "never":
+	default:
+		fmt.Fprintf(os.Stderr, "sgrep: unknown --mmap %q\n", *useMmap)
+		os.Exit(2)
+	}
+	switch *columnMode {
+	case "bytes", "runes", "visual":
+	default:
+		fmt.Fprintf(os.Stderr, "sgrep: unknown column mode %q\n", *columnMode)
+		os.Exit(2)
+	}
+	printer := writePlain
+	switch {
+	case *extract:
+		checkExtract()
+		printer = writeExtract
+	case *browse:
+		ui = newTUI()
+		printer = ui.collect
+	case *listScopes:
+		printer = writeOutline
+	case *format == "json":
+		printer = writeJSON
+	case *format == "ndjson":
+		printer = writeMatchEvent
+	case *format == "diff":
+		printer = writeHunks
+	case *format == "grep":
+		printer = writeGrep
+	case *countMatches:
+		printer = writeMatchCount
+	case *format != "text":
+		fmt.Fprintf(os.Stderr, "sgrep: unknown format %q\n", *format)
+		os.Exit(2)
+	case *pretty && colors:
+		printer = writePretty
+	}
+	text := *format == "text" && ui == nil && !*listScopes && !*extract
+	if *siblings && text {
+		printer = withSiblings(printer, colors && *pretty)
+	}
+	if text {
+		printer = withPaths(printer, colors && *pretty)
+	}
+	if *crumbs && text {
+		printer = withBreadcrumbs(printer, colors && *pretty)
+	}
+	if null && ui == nil {
+		printer = withNull(printer)
+	}
+	if *dedupe {
+		printer = withDedupe(printer)
+	}
+
+	inputs := []string{"-"}
+	if len(args) > 0 {
+		inputs = args
+	} else if recursive {
+		inputs = []string{"."}
+	}
+	if *serveAddr != "" {
+		if len(args) == 0 {
+			inputs = []string{"."}
+		}
+		if err := serve(*serveAddr, inputs); err != nil {
+			fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+			os.Exit(2)
+		}
+		return
+	}
+	// like grep, only prefix output with file names when there are several
+	showNames = len(inputs) > 1 || recursive || *archives
+	if *watching && (ui != nil || stdin(inputs)) {
+		fmt.Fprintln(os.Stderr, "sgrep: --watch needs files to search and no --tui")
+		os.Exit(2)
+	}
+	buffered := bufio.NewWriter(out)
+	if *extract && *format != "json" {
+		writeExtractHeader(buffered)
+	}
+	if following && (len(inputs) > 1 || recursive || ui != nil || *watching || *archives) {
+		fmt.Fprintln(os.Stderr, "sgrep: --follow needs a single file or stdin, and no --tui, --watch or --archive")
+		os.Exit(2)
+	}
+	if *maxTotal > 0 || ui != nil || following || *dedupe {
+		// scopes must be found in input order, the first ones or all
+		// of them for the tui or the first copies, followed ones are
+		// written as found
+		*jobs = 1
+	}
+	startProfiles()
+	startContext(*timeout)
+	searchAll(inputs, buffered, printer)
+	if ui != nil && len(ui.results) > 0 {
+		if err := ui.run(); err != nil {
+			warn("tui: %v", err)
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+		exit(2)
+	}
+	if *watching {
+		watch(inputs, *watchInterval, func() {
+			if *clearScreen {
+				io.WriteString(buffered, "\033[H\033[2J")
+			}
+			total.Store(0)
+			searchAll(inputs, buffered, printer)
+			buffered.Flush()
+		})
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "sgrep: %v\n", err)
+		exit(2)
+	}
+	stopProfiles()
+	switch {
+	case timedOut():
+		fmt.Fprintf(os.Stderr, "sgrep: timed out after %v\n", *timeout)
+		os.Exit(2)
+	case cancelled():
+		os.Exit(130) // like the shell reports an interrupted command
+	case failed.Load():
+		os.Exit(2)
+	case !matchedAny.Load() && !(*reindex && len(patterns) == 0):
+		os.Exit(1)
+	}
+}
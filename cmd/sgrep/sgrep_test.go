@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+func TestSearchBadLanguage(t *testing.T) {
+	defer func() { patterns = nil }()
+	patterns = []sgrep.Matcher{compile("needle")}
+	// a language from a config may not make a parser, unlike the options
+	lang := &sgrep.Language{Name: "bad", Mode: "bogus"}
+	in := bufio.NewReader(strings.NewReader("x {\n  needle\n}\n"))
+	if _, err := search("a.bad", lang, in, io.Discard, writePlain, nil); err == nil {
+		t.Error("no error searching with a language of an unknown mode")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// runStats collects the --stats of the inputs searched
+type runStats struct {
+	sync.Mutex
+	files []fileStats
+}
+
+type fileStats struct {
+	name string
+	sgrep.Stats
+	elapsed time.Duration
+}
+
+var report runStats
+
+func (r *runStats) add(name string, stats sgrep.Stats, elapsed time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.files = append(r.files, fileStats{name, stats, elapsed})
+}
+
+// write a table with a row per input and their totals, then start over.
+// The total time is the wall time of the run, inputs are searched in
+// parallel.
+func (r *runStats) write(out io.Writer, elapsed time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	sort.Slice(r.files, func(i, j int) bool { return r.files[i].name < r.files[j].name })
+	total := fileStats{name: "total", elapsed: elapsed}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "file\tlines\topened\tclosed\tdepth\tunclosed\tunexpected\ttoo deep\tmatches\tbuffered\ttime")
+	for _, f := range r.files {
+		writeStats(w, f)
+		total.Lines += f.Lines
+		total.Opened += f.Opened
+		total.Closed += f.Closed
+		total.Unclosed += f.Unclosed
+		total.Unexpected += f.Unexpected
+		total.TooDeep += f.TooDeep
+		total.Matches += f.Matches
+		if f.MaxDepth > total.MaxDepth {
+			total.MaxDepth = f.MaxDepth
+		}
+		if f.Buffered > total.Buffered {
+			total.Buffered = f.Buffered
+		}
+	}
+	if len(r.files) > 1 {
+		writeStats(w, total)
+	}
+	w.Flush()
+	r.files = nil
+}
+
+func writeStats(w io.Writer, f fileStats) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%v\n", f.name, f.Lines, f.Opened, f.Closed,
+		f.MaxDepth, f.Unclosed, f.Unexpected, f.TooDeep, f.Matches, f.Buffered, f.elapsed.Round(time.Microsecond))
+}
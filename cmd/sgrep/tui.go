@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/rodolf0/sgrep/sgrep"
+)
+
+// tui browses the matched scopes of every input on the terminal. Results
+// are collected first, the screen is drawn with plain ANSI sequences and
+// the terminal put in raw mode with stty, once, until quitting.
+type tui struct {
+	tty     *os.File
+	results []tuiResult
+	lines   map[string][][]byte // all lines of each input, to show parent scopes
+	sel     int                 // selected result
+	top     int                 // first result in the list pane
+	scroll  int                 // first line of the scope pane
+	status  string
+	rows    int
+	cols    int
+}
+
+// tuiResult is a matched scope, or one of its parents once expanded
+type tuiResult struct {
+	name    string
+	scope   *sgrep.Scope
+	matched *sgrep.Scope
+}
+
+const tuiHelp = "j/k select  J/K scroll  n next match  p parent  u back  y copy  ^L resize  q quit"
+
+func newTUI() *tui {
+	return &tui{lines: make(map[string][][]byte)}
+}
+
+// keep a line read from an input, they're read in order
+func (t *tui) keep(name string, line []byte) {
+	t.lines[name] = append(t.lines[name], line)
+}
+
+// drop the lines of an input without results, only those with results
+// are browsed
+func (t *tui) drop(name string) {
+	delete(t.lines, name)
+}
+
+// collect is the printer used with --tui
+func (t *tui) collect(out io.Writer, name string, r *sgrep.Result) {
+	t.results = append(t.results, tuiResult{name: name, scope: r.Scope, matched: r.Scope})
+}
+
+// run takes over the terminal until the user quits
+func (t *tui) run() error {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	t.tty = tty
+	saved, err := t.stty("-g")
+	if err != nil {
+		return err
+	}
+	if _, err := t.stty("raw", "-echo"); err != nil {
+		return err
+	}
+	defer t.stty(saved)
+	// alternate screen without cursor, restored on the way out
+	io.WriteString(tty, "\033[?1049h\033[?25l")
+	defer io.WriteString(tty, "\033[?25h\033[?1049l")
+	t.size()
+	key := make([]byte, 16)
+	for {
+		t.draw()
+		n, err := tty.Read(key)
+		if err != nil {
+			return err
+		}
+		if !t.key(string(key[:n])) {
+			return nil
+		}
+	}
+}
+
+func (t *tui) stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = t.tty
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// size reads the terminal size, at start and again on ^L. Terminals not
+// telling are taken as 80x24.
+func (t *tui) size() {
+	t.rows, t.cols = 24, 80
+	var rows, cols int
+	if out, err := t.stty("size"); err == nil {
+		fmt.Sscan(out, &rows, &cols)
+	}
+	if rows > 0 && cols > 0 {
+		t.rows, t.cols = rows, cols
+	}
+}
+
+// key handles a key press, returns false to quit
+func (t *tui) key(k string) bool {
+	t.status = ""
+	r := &t.results[t.sel]
+	switch k {
+	case "q", "\033", "\003":
+		return false
+	case "\014":
+		t.size() // ^L after resizing the terminal
+	case "j", "\033[B":
+		t.selectResult(t.sel + 1)
+	case "k", "\033[A":
+		t.selectResult(t.sel - 1)
+	case "J", " ", "\033[6~":
+		t.scroll += t.bodyRows() / 2
+	case "K", "b", "\033[5~":
+		t.scroll -= t.bodyRows() / 2
+	case "n":
+		t.nextMatch()
+	case "p":
+		if r.scope.Parent != nil {
+			r.scope = r.scope.Parent
+			t.scroll = 0
+		} else {
+			t.status = "no parent scope"
+		}
+	case "u":
+		r.scope = r.matched
+		t.scroll = 0
+	case "y":
+		loc := t.location(r)
+		fmt.Fprintf(t.tty, "\033]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(loc)))
+		t.status = "copied " + loc
+	}
+	t.clamp()
+	return true
+}
+
+func (t *tui) selectResult(n int) {
+	if n >= 0 && n < len(t.results) {
+		t.sel, t.scroll = n, 0
+	}
+}
+
+// nextMatch scrolls to the next line with matches, on to the next result
+// when there are no more
+func (t *tui) nextMatch() {
+	for sel, from := t.sel, t.scroll+1; sel < len(t.results); sel, from = sel+1, 0 {
+		for n, line := range t.body(&t.results[sel]) {
+			if n >= from && len(t.matches(line)) > 0 {
+				t.sel, t.scroll = sel, n
+				return
+			}
+		}
+	}
+	t.status = "no more matches"
+}
+
+// location of a scope like compilers report them
+func (t *tui) location(r *tuiResult) string {
+	start := r.scope.Start
+	return fmt.Sprintf("%s:%d:%d", r.name, start.Line.Num+1, columnOf(r.name, start.Line.Text, start.Col)+1)
+}
+
+// body are the lines of the scope shown for a result
+func (t *tui) body(r *tuiResult) [][]byte {
+	lines := t.lines[r.name]
+	start, end := r.scope.Start.Line.Num, uint(len(lines))
+	if r.scope.End != nil && r.scope.End.Line.Num < end {
+		end = r.scope.End.Line.Num + 1
+	}
+	if start >= end {
+		return nil
+	}
+	return lines[start:end]
+}
+
+func (t *tui) matches(line []byte) spans {
+	var hl spans
+	for _, re := range patterns {
+		for _, loc := range re.FindAllIndex(bytes.TrimRight(line, "\r\n"), -1) {
+			hl = append(hl, span{uint(loc[0]), uint(loc[1]), colorMatch})
+		}
+	}
+	return hl
+}
+
+// listRows is the height of the list pane, the scope pane gets the rest
+// but for the status line
+func (t *tui) listRows() int {
+	n := t.rows / 3
+	if n > len(t.results) {
+		n = len(t.results)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func (t *tui) bodyRows() int {
+	return t.rows - t.listRows() - 1
+}
+
+// clamp keeps the selection visible and the scope pane within the scope
+func (t *tui) clamp() {
+	rows := t.listRows()
+	if t.sel < t.top {
+		t.top = t.sel
+	} else if t.sel >= t.top+rows {
+		t.top = t.sel - rows + 1
+	}
+	if max := len(t.body(&t.results[t.sel])) - t.bodyRows(); t.scroll > max {
+		t.scroll = max
+	}
+	if t.scroll < 0 {
+		t.scroll = 0
+	}
+}
+
+func (t *tui) draw() {
+	t.clamp()
+	var screen bytes.Buffer
+	screen.WriteString("\033[H\033[2J")
+	for n := t.top; n < t.top+t.listRows() && n < len(t.results); n++ {
+		r := &t.results[n]
+		s := r.scope
+		end := "*"
+		if s.End != nil {
+			end = fmt.Sprint(s.End.Line.Num + 1)
+		}
+		entry := fmt.Sprintf("%s:%d-%s ", r.name, s.Start.Line.Num+1, end)
+		if n == t.sel {
+			screen.WriteString("\033[7m")
+		}
+		drawLine(&screen, []byte(entry+string(bytes.TrimSpace(s.Start.Line.Text))), nil, t.cols)
+		screen.WriteString(colorReset + "\r\n")
+	}
+	status := t.status
+	if status == "" {
+		status = t.location(&t.results[t.sel]) + "  " + tuiHelp
+	}
+	screen.WriteString(colorDim)
+	drawLine(&screen, []byte(status), nil, t.cols)
+	screen.WriteString(colorReset)
+	r := &t.results[t.sel]
+	body := t.body(r)
+	for n := t.scroll; n < t.scroll+t.bodyRows() && n < len(body); n++ {
+		screen.WriteString("\r\n")
+		num := r.scope.Start.Line.Num + uint(n) + 1
+		fmt.Fprintf(&screen, "%s%5d%s ", colorLine, num, colorReset)
+		drawLine(&screen, body[n], t.matches(body[n]), t.cols-6)
+	}
+	t.tty.Write(screen.Bytes())
+}
+
+// drawLine writes text cut to width columns, with tabs expanded and the
+// spans highlighted
+func drawLine(out *bytes.Buffer, text []byte, hl spans, width int) {
+	text = bytes.TrimRight(text, "\r\n")
+	colors := make([]string, len(text))
+	for _, h := range hl {
+		for i := h.start; i < h.end && int(i) < len(text); i++ {
+			colors[i] = h.color
+		}
+	}
+	col, current := 0, ""
+	for i, r := range string(text) {
+		if colors[i] != current {
+			out.WriteString(colorReset + colors[i])
+			current = colors[i]
+		}
+		switch {
+		case r == '\t' && *tabWidth > 0:
+			stop := col + int(*tabWidth) - col%int(*tabWidth)
+			for ; col < stop && col < width; col++ {
+				out.WriteByte(' ')
+			}
+			continue
+		case r < ' ':
+			r = '?'
+		}
+		if col >= width {
+			break
+		}
+		out.WriteRune(r)
+		col++
+	}
+	if current != "" {
+		out.WriteString(colorReset)
+	}
+}
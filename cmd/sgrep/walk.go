@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// walker finds the files to search below the directories given
+type walker struct {
+	include   globList // only search files matching these
+	exclude   globList // skip files and directories matching these
+	gitignore bool     // honor .gitignore and .ignore files
+}
+
+// walk calls fn on every regular file below root. Symlinks are followed,
+// directories already being visited up the current path are skipped so
+// symlink loops don't recurse forever.
+func (w *walker) walk(root string, fn func(path string)) {
+	w.walkDir(root, nil, nil, fn)
+}
+
+func (w *walker) walkDir(path string, ancestors []os.FileInfo, ignore *ignoreRules, fn func(path string)) {
+	if cancelled() {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		warn("%v", err)
+		return
+	}
+	// don't filter the roots the user asked for explicitly
+	if len(ancestors) > 0 {
+		if w.exclude.matchAny(path) || ignore.ignored(path, info.IsDir()) {
+			return
+		}
+		if w.gitignore && info.IsDir() && info.Name() == ".git" {
+			return
+		}
+	}
+	if info.Mode().IsRegular() {
+		if len(w.include) == 0 || w.include.matchAny(path) {
+			fn(path)
+		}
+		return
+	}
+	if !info.IsDir() {
+		return // devices, pipes, sockets
+	}
+	for _, a := range ancestors {
+		if os.SameFile(a, info) {
+			warn("%v: recursive directory loop", path)
+			return
+		}
+	}
+	d, err := os.Open(path)
+	if err != nil {
+		warn("%v", err)
+		return
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		warn("%v", err)
+	}
+	sort.Strings(names)
+	if w.gitignore {
+		ignore = loadIgnoreRules(path, ignore)
+	}
+	ancestors = append(ancestors, info)
+	for _, name := range names {
+		w.walkDir(filepath.Join(path, name), ancestors, ignore, fn)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// stamp tells if a file changed, along with its path
+type stamp struct {
+	mod  time.Time
+	size int64
+}
+
+// stamps of all the files an input list expands to
+func stamps(inputs []string) map[string]stamp {
+	found := make(map[string]stamp)
+	add := func(path string) {
+		if info, err := os.Stat(path); err == nil {
+			found[path] = stamp{info.ModTime(), info.Size()}
+		}
+	}
+	for _, input := range inputs {
+		if info, err := os.Stat(input); err == nil && info.IsDir() && recursive {
+			files.walk(input, add)
+		} else {
+			add(input)
+		}
+	}
+	return found
+}
+
+func sameStamps(a, b map[string]stamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, s := range a {
+		if t, ok := b[path]; !ok || !s.mod.Equal(t.mod) || s.size != t.size {
+			return false
+		}
+	}
+	return true
+}
+
+// watch calls run every time the inputs change, until interrupted. Files
+// are polled, there's no portable change notification in the standard
+// library. Editors save in several steps, so changes must settle for an
+// interval before running again.
+func watch(inputs []string, interval time.Duration, run func()) {
+	last := stamps(inputs)
+	for !cancelled() {
+		time.Sleep(interval)
+		now := stamps(inputs)
+		if sameStamps(last, now) {
+			continue
+		}
+		for {
+			time.Sleep(interval)
+			settled := stamps(inputs)
+			if sameStamps(now, settled) {
+				break
+			}
+			now = settled
+		}
+		last = now
+		run()
+	}
+}
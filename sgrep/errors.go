@@ -0,0 +1,53 @@
+package sgrep
+
+import "fmt"
+
+// ErrorKind tells what went wrong with an input
+type ErrorKind string
+
+const (
+	IOError         ErrorKind = "io"                   // reading failed
+	EncodingError   ErrorKind = "encoding"             // the input isn't valid, for decoding readers to report
+	UnbalancedError ErrorKind = "unbalanced-delimiter" // a delimiter left unpaired
+)
+
+// Error is a problem with an input and where it was found
+type Error struct {
+	File string // Options.Name of the input
+	Line uint   // 1-based, 0 if unknown
+	Col  uint   // 1-based byte offset in Line, 0 if unknown
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	where := e.File
+	if e.Line > 0 {
+		where += fmt.Sprintf(":%d", e.Line)
+		if e.Col > 0 {
+			where += fmt.Sprintf(":%d", e.Col)
+		}
+	}
+	if where == "" {
+		return e.Err.Error()
+	}
+	return where + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// inputError makes err an *Error of the input, those from readers that
+// are already one get the file and line if they lack them
+func inputError(err error, name string, line uint) *Error {
+	e, ok := err.(*Error)
+	if !ok {
+		return &Error{File: name, Line: line, Kind: IOError, Err: err}
+	}
+	if e.File == "" {
+		e.File = name
+	}
+	if e.Line == 0 {
+		e.Line = line
+	}
+	return e
+}
@@ -0,0 +1,57 @@
+package sgrep
+
+import (
+	goscanner "go/scanner"
+	"go/token"
+)
+
+// goKind classifies Go scopes by the tokens of their opening line, telling
+// methods from functions and structs from interfaces. Lines go/scanner
+// can't make sense of are left to the Kinds regexps.
+func goKind(header []byte) string {
+	var s goscanner.Scanner
+	errors := 0
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile("", -1, len(header)), header, func(token.Position, string) { errors++ }, 0)
+	var toks []token.Token
+	for {
+		_, tok, _ := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		// a newline at the end is taken as an automatic semicolon
+		if tok != token.SEMICOLON && tok != token.COMMENT {
+			toks = append(toks, tok)
+		}
+	}
+	if errors > 0 || len(toks) == 0 {
+		return ""
+	}
+	switch {
+	case toks[0] == token.FUNC && len(toks) > 1 && toks[1] == token.LPAREN:
+		return "method"
+	case toks[0] == token.FUNC:
+		return "function"
+	case len(toks) == 1 && toks[0] == token.LBRACE:
+		return "block"
+	}
+	for i, tok := range toks {
+		switch tok {
+		case token.FUNC:
+			return "function"
+		case token.STRUCT:
+			return "struct"
+		case token.INTERFACE:
+			if i+1 < len(toks) && toks[i+1] == token.LBRACE && (i+2 == len(toks) || toks[i+2] != token.RBRACE) {
+				return "interface"
+			}
+		case token.FOR:
+			return "loop"
+		case token.IF, token.ELSE:
+			return "if"
+		case token.SWITCH, token.SELECT:
+			return "switch"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package sgrep
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the .golden files of testdata")
+
+// TestGolden searches the fixtures of testdata, comparing the scopes found
+// with those in the .golden files. Run with -update after changes to the
+// parser that are meant to change them, and review the diff.
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		name    string // of the .golden file
+		file    string
+		pattern string
+		opts    Options
+	}{
+		{"go", "code.go", `os\.`, Options{}},
+		{"go-keep-all", "code.go", `retries`, Options{Keep: "all", Scopes: 2}},
+		{"c", "code.c", `fgetc|stderr`, Options{}},
+		{"json", "data.json", `"beta"|timeout`, Options{}},
+		{"log", "app.log", `ERROR|WARN`, Options{}},
+	}
+	for _, tc := range tests {
+		path := filepath.Join("testdata", tc.file)
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := tc.opts
+		opts.Language, opts.Name = DetectLanguage(path), path
+		results, err := Search(f, regexp.MustCompile(tc.pattern), opts)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		for _, r := range results {
+			fmt.Fprintf(&got, "== %v", r.Scope)
+			if r.Scope.Kind != "" {
+				fmt.Fprintf(&got, " %s", r.Scope.Kind)
+			}
+			got.WriteString("\n")
+			for _, l := range r.Lines {
+				fmt.Fprintf(&got, "%d: %s", l.Num+1, l.Text)
+			}
+		}
+		golden := filepath.Join("testdata", tc.name+".golden")
+		if *update {
+			if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("%s /%s/ differs from %s:\n%s", tc.file, tc.pattern, golden, got.Bytes())
+		}
+	}
+}
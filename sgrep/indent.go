@@ -0,0 +1,110 @@
+package sgrep
+
+import "bytes"
+
+const tabWidth = 8
+
+// delimiters of indentation scopes have no text of their own
+var indentOpen, indentClose = &Delimiter{Str: "", Open: true}, &Delimiter{Str: "", Open: false}
+
+func init() {
+	indentOpen.Pair, indentClose.Pair = indentClose, indentOpen
+}
+
+// indentScanner defines scopes by indentation, python style. A line opens a
+// scope when the lines following it are more indented, the scope closes when
+// indentation gets back to the opening line's level. Blank and comment only
+// lines don't affect scopes, neither do lines within multi-line literals.
+type indentScanner struct {
+	comments []string
+	tokens   *tokenizer
+	stack    []int // indentation of the lines opening each scope
+	last     *Line // last non blank line, may open a scope
+	indent   int   // indentation of last
+	// yaml documents get paths for their scopes, see yamlPath
+	yaml     bool
+	paths    []*yamlFrame // of the scopes in stack
+	root     yamlFrame
+	lastPath string // path of last
+}
+
+func (s *indentScanner) scan(l *Line) Markers {
+	literal := s.tokens.inLiteral()
+	l.regions = s.tokens.regions(l.Text)
+	measure := indentation
+	if s.yaml {
+		measure = yamlIndentation
+	}
+	indent, col, blank := measure(l.Text)
+	if literal || blank || hasPrefixAt(l.Text, col, s.comments) {
+		return nil
+	}
+	var markers Markers
+	for len(s.stack) > 0 && s.stack[len(s.stack)-1] >= indent {
+		markers = append(markers, s.closeLast())
+		s.stack = s.stack[:len(s.stack)-1]
+		if s.yaml {
+			s.paths = s.paths[:len(s.paths)-1]
+		}
+	}
+	if s.last != nil && indent > s.indent {
+		_, lastCol, _ := indentation(s.last.Text)
+		open := &Marker{Delim: indentOpen, Line: s.last, Col: uint(lastCol)}
+		markers = append(markers, open)
+		s.stack = append(s.stack, s.indent)
+		if s.yaml {
+			open.Path = s.lastPath
+			s.paths = append(s.paths, &yamlFrame{path: s.lastPath})
+		}
+	}
+	if s.yaml {
+		parent := &s.root
+		if len(s.paths) > 0 {
+			parent = s.paths[len(s.paths)-1]
+		}
+		s.lastPath = yamlPath(parent, l.Text, col)
+	}
+	s.last, s.indent = l, indent
+	return markers
+}
+
+// lines after the last non blank one could still be in a scope it opens
+func (s *indentScanner) settled() uint {
+	if s.last == nil {
+		return 0
+	}
+	return s.last.Num
+}
+
+func (s *indentScanner) finish() Markers {
+	var markers Markers
+	for ; len(s.stack) > 0; s.stack = s.stack[:len(s.stack)-1] {
+		markers = append(markers, s.closeLast())
+	}
+	return markers
+}
+
+// scopes close at the end of the last non blank line
+func (s *indentScanner) closeLast() *Marker {
+	end := len(bytes.TrimRight(s.last.Text, " \t\r\n"))
+	return &Marker{Delim: indentClose, Line: s.last, Col: uint(end)}
+}
+
+// indentation returns the visual width of leading whitespace, the column of
+// the first non blank character and if the line is all blank
+func indentation(line []byte) (int, int, bool) {
+	width := 0
+	for i, c := range line {
+		switch c {
+		case ' ':
+			width++
+		case '\t':
+			width += tabWidth - width%tabWidth
+		case '\r', '\n':
+			return width, i, true
+		default:
+			return width, i, false
+		}
+	}
+	return width, len(line), true
+}
@@ -0,0 +1,135 @@
+package sgrep
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// objects and arrays are the scopes of json documents
+var jsonObject, jsonObjectEnd = &Delimiter{Str: "{", Open: true}, &Delimiter{Str: "}", Open: false}
+var jsonArray, jsonArrayEnd = &Delimiter{Str: "[", Open: true}, &Delimiter{Str: "]", Open: false}
+
+func init() {
+	jsonObject.Pair, jsonObjectEnd.Pair = jsonObjectEnd, jsonObject
+	jsonArray.Pair, jsonArrayEnd.Pair = jsonArrayEnd, jsonArray
+}
+
+// jsonValue is an object or array being read
+type jsonValue struct {
+	path  string
+	array bool
+	index int    // of the next element of an array
+	key   string // last key read in an object
+}
+
+// jsonScanner reads json documents, objects and arrays are scopes named
+// with their path like jq does, ie: .spec.containers[2].env
+type jsonScanner struct {
+	stack   []*jsonValue
+	str     []byte // string being read, if inString
+	inStr   bool
+	escaped bool
+	next    uint
+}
+
+func (s *jsonScanner) scan(l *Line) Markers {
+	var markers Markers
+	for i, c := range l.Text {
+		if s.inStr {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case c == '\\':
+				s.escaped = true
+			case c == '"':
+				s.inStr = false
+				s.endString()
+				continue
+			}
+			s.str = append(s.str, c)
+			continue
+		}
+		switch c {
+		case '"':
+			s.inStr, s.str = true, s.str[:0]
+		case '{', '[':
+			path := s.childPath()
+			delim := jsonObject
+			if c == '[' {
+				delim = jsonArray
+			}
+			s.stack = append(s.stack, &jsonValue{path: path, array: c == '['})
+			markers = append(markers, &Marker{Delim: delim, Line: l, Col: uint(i), Path: path})
+		case '}', ']':
+			delim := jsonObjectEnd
+			if c == ']' {
+				delim = jsonArrayEnd
+			}
+			if len(s.stack) > 0 {
+				s.stack = s.stack[:len(s.stack)-1]
+			}
+			markers = append(markers, &Marker{Delim: delim, Line: l, Col: uint(i)})
+		case ',':
+			if top := s.top(); top != nil && top.array {
+				top.index++
+			} else if top != nil {
+				top.key = ""
+			}
+		}
+	}
+	s.next = l.Num + 1
+	return markers
+}
+
+func (s *jsonScanner) top() *jsonValue {
+	if len(s.stack) == 0 {
+		return nil
+	}
+	return s.stack[len(s.stack)-1]
+}
+
+// strings read where an object expects a key are the key
+func (s *jsonScanner) endString() {
+	if top := s.top(); top != nil && !top.array && top.key == "" {
+		key, err := strconv.Unquote(`"` + string(s.str) + `"`)
+		if err != nil {
+			key = string(s.str)
+		}
+		top.key = key
+	}
+}
+
+// childPath is the path of a value starting at this point
+func (s *jsonScanner) childPath() string {
+	top := s.top()
+	switch {
+	case top == nil:
+		return "."
+	case top.array:
+		return indexPath(top.path, strconv.Itoa(top.index))
+	}
+	return keyPath(top.path, top.key)
+}
+
+// identifiers are plain keys in paths, others get quoted
+var pathIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// paths within documents are written like jq does, ie: .spec.containers[2],
+// the root is "." or empty
+func keyPath(parent, key string) string {
+	if pathIdent.MatchString(key) {
+		return strings.TrimSuffix(parent, ".") + "." + key
+	}
+	return indexPath(parent, strconv.Quote(key))
+}
+
+func indexPath(parent, index string) string {
+	if parent == "" {
+		parent = "."
+	}
+	return parent + "[" + index + "]"
+}
+
+func (s *jsonScanner) settled() uint   { return s.next }
+func (s *jsonScanner) finish() Markers { return nil }
@@ -0,0 +1,262 @@
+package sgrep
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Language holds the rules to find scopes in a kind of source: which
+// delimiters open and close them and the comment and string literal
+// syntax needed to tell real delimiters apart from quoted ones.
+type Language struct {
+	Name          string
+	Exts          []string
+	Mode          string                     // default scoping mode, delim if empty
+	Delims        [][2]string                // scope delimiter pairs, brackets if nil
+	Quotes        string                     // characters opening a string literal
+	LineComments  []string                   // prefixes commenting out the rest of a line
+	BlockComments [][2]string                // open/close tokens of multi-line comments
+	Literals      [][2]string                // open/close tokens of raw literals spanning lines
+	Heredocs      bool                       // shell style <<WORD literals up to a line with WORD
+	Escapes       bool                       // delimiters after an odd number of backslashes don't count
+	Chars         []string                   // prefixes of character literals, ie: #\( in lisp
+	Preprocessor  bool                       // C directives and the branches they leave out aren't code
+	CaseArms      bool                       // shell case patterns) up to ;; are scopes too
+	Keywords      bool                       // word delimiters aren't keywords after . or : nor before :
+	Statements    []string                   // keyword openers only counting first in a statement
+	NoCase        bool                       // delimiters match in any case
+	NotDelims     *regexp.Regexp             // matching at a delimiter makes it not one, ie: BEGIN TRANSACTION
+	Terminator    string                     // ends statements, which are scopes too
+	Blocks        []string                   // openers of scopes holding statements
+	Kinds         []Kind                     // classify scopes by their opening line, GenericKinds if nil
+	FormKinds     bool                       // Kinds match from the opening delimiter instead, lisp style
+	Classify      func(header []byte) string // overrides Kinds when set, "" if unsure
+}
+
+// Kind of scope, given to the scopes whose opening line matches Pattern
+type Kind struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// GenericKinds recognize scopes by the keywords common to most languages,
+// the first matching kind wins
+var GenericKinds = []Kind{
+	{"function", regexp.MustCompile(`\b(func|function|def|fn|sub|proc|lambda)\b`)},
+	{"class", regexp.MustCompile(`\b(class|struct|interface|trait|impl|enum|module|namespace)\b`)},
+	{"loop", regexp.MustCompile(`\b(for|foreach|while|until|loop|do)\b`)},
+	{"if", regexp.MustCompile(`\b(if|else|elif|elsif|unless)\b`)},
+	{"switch", regexp.MustCompile(`\b(switch|match|case|select|when)\b`)},
+}
+
+// cKinds also take declarations like `int main(void) {` as functions
+var cKinds = append([]Kind{
+	{"function", regexp.MustCompile(`^[A-Za-z_][\w\s\*&:<>,]*\s[\*&]*~?[A-Za-z_][\w:]*\s*\([^;]*\)\s*(const\s*)?(\{\s*)?$`)},
+}, GenericKinds...)
+
+// shellKinds also take `name() {` as functions
+var shellKinds = append([]Kind{
+	{"function", regexp.MustCompile(`^\s*(function\s+[\w.:-]+|[\w.:-]+\s*\(\s*\))`)},
+}, GenericKinds...)
+
+// elixirKinds know the def macros
+var elixirKinds = append([]Kind{
+	{"function", regexp.MustCompile(`\b(def|defp|defmacro|defmacrop|fn)\b`)},
+	{"class", regexp.MustCompile(`\b(defmodule|defprotocol|defimpl)\b`)},
+}, GenericKinds...)
+
+// sqlKinds know the statements creating routines
+var sqlKinds = append([]Kind{
+	{"function", regexp.MustCompile(`(?i)\bcreate\s+(or\s+replace\s+)?(function|procedure|trigger)\b`)},
+	{"switch", regexp.MustCompile(`(?i)\bcase\b`)},
+	{"loop", regexp.MustCompile(`(?i)\b(loop|while)\b`)},
+	{"if", regexp.MustCompile(`(?i)\bif\b`)},
+}, GenericKinds...)
+
+// lispKinds look at the form a scope opens with, see FormKinds
+var lispKinds = []Kind{
+	{"function", regexp.MustCompile(`^\((defun|defmacro|defn-?|defmethod|defgeneric|define|define-syntax|lambda|fn)\b`)},
+	{"class", regexp.MustCompile(`^\((defclass|defstruct|defrecord|deftype|defprotocol|define-record-type|ns|defpackage)\b`)},
+	{"loop", regexp.MustCompile(`^\((loop|do|dolist|dotimes|doseq|for)\b`)},
+	{"if", regexp.MustCompile(`^\((if|when|unless|if-let|when-let)\b`)},
+	{"switch", regexp.MustCompile(`^\((cond|case|condp|match|typecase|ecase)\b`)},
+}
+
+// KindNames are the kinds of scopes known
+var KindNames = []string{"function", "method", "class", "struct", "interface", "loop", "if", "switch", "block"}
+
+var (
+	cComments   = [][2]string{{"/*", "*/"}}
+	cStyle      = []string{"//"}
+	shellStyle  = []string{"#"}
+	brackets    = [][2]string{{"(", ")"}, {"[", "]"}, {"{", "}"}}
+	jsonDelims  = [][2]string{{"[", "]"}, {"{", "}"}}
+	parenDelims = [][2]string{{"(", ")"}}
+	// all keyword openers close with end, those of statements don't
+	// count as modifiers, like `return if x`
+	rubyDelims = append([][2]string{{"def", "end"}, {"class", "end"}, {"module", "end"},
+		{"if", "end"}, {"unless", "end"}, {"while", "end"}, {"until", "end"}, {"for", "end"},
+		{"case", "end"}, {"begin", "end"}, {"do", "end"}}, brackets...)
+	rubyStatements = []string{"def", "class", "module", "if", "unless", "while", "until", "for", "case", "begin"}
+	luaDelims      = append([][2]string{{"function", "end"}, {"if", "end"}, {"do", "end"},
+		{"repeat", "until"}}, brackets...)
+	elixirDelims = append([][2]string{{"do", "end"}, {"fn", "end"}}, brackets...)
+	sqlDelims    = [][2]string{{"(", ")"}, {"begin", "end"}, {"case", "end"}}
+	// transactions begin without an end and procedural blocks end with what
+	// they are, only begin and case are tracked
+	sqlNotDelims = regexp.MustCompile(`(?i)^(begin\s*(;|\b(transaction|tran|work|deferred|immediate|exclusive)\b)|end\s+(if|loop|while|repeat|for)\b)`)
+	shellDelims  = append([][2]string{{"if", "fi"}, {"case", "esac"}, {"do", "done"}}, brackets...)
+	// longest first, openers are tried in order
+	tripleQuotes = [][2]string{{`"""`, `"""`}, {"'''", "'''"}}
+	rustRaw      = [][2]string{{`r##"`, `"##`}, {`r#"`, `"#`}, {`br"`, `"`}, {`r"`, `"`}}
+)
+
+// Generic is used when the input language is unknown
+var Generic = &Language{Name: "generic", Delims: brackets, Quotes: `"'`,
+	BlockComments: cComments, Escapes: true}
+
+// Languages known by extension or name
+var Languages = []*Language{
+	Generic,
+	{Name: "c", Exts: []string{".c", ".h"},
+		Quotes: `"'`, LineComments: cStyle, BlockComments: cComments, Kinds: cKinds, Escapes: true,
+		Preprocessor: true},
+	{Name: "cpp", Exts: []string{".cc", ".cpp", ".cxx", ".hh", ".hpp"},
+		Quotes: `"'`, LineComments: cStyle, BlockComments: cComments, Kinds: cKinds, Escapes: true,
+		Preprocessor: true},
+	{Name: "go", Exts: []string{".go"},
+		Quotes: `"'`, LineComments: cStyle, BlockComments: cComments, Escapes: true,
+		Literals: [][2]string{{"`", "`"}}, Classify: goKind},
+	{Name: "java", Exts: []string{".java", ".kt", ".scala"},
+		Quotes: `"'`, LineComments: cStyle, BlockComments: cComments, Kinds: cKinds, Escapes: true},
+	{Name: "js", Exts: []string{".js", ".jsx", ".ts", ".tsx"},
+		Quotes: `"'`, LineComments: cStyle, BlockComments: cComments, Escapes: true,
+		Literals: [][2]string{{"`", "`"}}},
+	{Name: "rust", Exts: []string{".rs"}, // ' also starts lifetimes
+		Quotes: `"`, LineComments: cStyle, BlockComments: cComments, Literals: rustRaw, Escapes: true},
+	{Name: "css", Exts: []string{".css"},
+		Quotes: `"'`, BlockComments: cComments, Escapes: true},
+	{Name: "json", Exts: []string{".json"}, Delims: jsonDelims, Quotes: `"`},
+	{Name: "python", Exts: []string{".py"}, Mode: "indent",
+		Quotes: `"'`, LineComments: shellStyle, Literals: tripleQuotes, Escapes: true},
+	{Name: "yaml", Exts: []string{".yml", ".yaml"}, Mode: "indent",
+		Quotes: `"'`, LineComments: shellStyle},
+	{Name: "ruby", Exts: []string{".rb", ".rake", ".gemspec"}, Quotes: `"'`, LineComments: shellStyle,
+		BlockComments: [][2]string{{"=begin", "=end"}}, Heredocs: true, Escapes: true,
+		Delims: rubyDelims, Keywords: true, Statements: rubyStatements},
+	{Name: "elixir", Exts: []string{".ex", ".exs"}, Quotes: `"'`, LineComments: shellStyle,
+		Literals: tripleQuotes, Escapes: true, Delims: elixirDelims, Keywords: true, Kinds: elixirKinds},
+	{Name: "shell", Exts: []string{".sh", ".bash", ".zsh"},
+		Quotes: `"'`, LineComments: shellStyle, Heredocs: true, Escapes: true,
+		Delims: shellDelims, Kinds: shellKinds, CaseArms: true},
+	{Name: "perl", Exts: []string{".pl", ".pm"}, Quotes: `"'`, LineComments: shellStyle,
+		Heredocs: true, Escapes: true},
+	{Name: "sql", Exts: []string{".sql"}, Delims: sqlDelims, NoCase: true, NotDelims: sqlNotDelims,
+		Terminator: ";", Blocks: []string{"begin"}, Kinds: sqlKinds,
+		Quotes: `'"`, LineComments: []string{"--"}, BlockComments: cComments},
+	{Name: "lua", Exts: []string{".lua"}, Quotes: `"'`, LineComments: []string{"--"},
+		BlockComments: [][2]string{{"--[[", "]]"}}, Delims: luaDelims, Keywords: true, Escapes: true},
+	{Name: "haskell", Exts: []string{".hs"}, Quotes: `"`, LineComments: []string{"--"},
+		BlockComments: [][2]string{{"{-", "-}"}}},
+	{Name: "lisp", Exts: []string{".lisp", ".lsp", ".cl", ".el", ".scm", ".ss", ".rkt", ".clj", ".cljs", ".cljc", ".edn", ".fnl"},
+		Quotes: `"`, LineComments: []string{";"}, BlockComments: [][2]string{{"#|", "|#"}},
+		Chars: []string{`\`}, Kinds: lispKinds, FormKinds: true, Escapes: true},
+	{Name: "html", Exts: []string{".html", ".htm"}, Mode: "xml",
+		Quotes: `"'`, BlockComments: [][2]string{{"<!--", "-->"}}},
+	{Name: "log", Exts: []string{".log"}, Mode: "logs"},
+	{Name: "markdown", Exts: []string{".md", ".markdown"}, Mode: "markdown"},
+	{Name: "xml", Exts: []string{".xml", ".svg", ".xsd", ".xsl", ".plist"}, Mode: "xml",
+		Quotes: `"'`, BlockComments: [][2]string{{"<!--", "-->"}}},
+}
+
+// delimSet builds the delimiters in use for the language. Custom
+// delimiters replace the language ones and block comments can be made
+// scopes too.
+func (l *Language) delimSet(opts *Options) delimSet {
+	set := make(delimSet)
+	switch {
+	case len(opts.Delims) > 0:
+		set.add(opts.Delims)
+	case l.Delims != nil:
+		set.add(l.Delims)
+	default:
+		set.add(brackets)
+	}
+	if opts.CommentScopes {
+		set.add(l.BlockComments)
+	}
+	for _, d := range set {
+		d.Fold = l.NoCase
+	}
+	return set
+}
+
+// tokenizer for the language's comments and literals, literals are left
+// out with Options.NoLiterals
+func (l *Language) tokenizer(opts *Options) *tokenizer {
+	t := newTokenizer(l.LineComments, l.BlockComments)
+	if !opts.NoLiterals {
+		t.quotes, t.literals, t.heredocs, t.chars = l.Quotes, l.Literals, l.Heredocs, l.Chars
+	}
+	t.preprocessor = l.Preprocessor
+	return t
+}
+
+// kind classifies a scope by its opening line
+func (l *Language) kind(header []byte) string {
+	if l.Classify != nil {
+		if k := l.Classify(header); k != "" {
+			return k
+		}
+	}
+	kinds := l.Kinds
+	if kinds == nil {
+		kinds = GenericKinds
+	}
+	for _, k := range kinds {
+		if k.Pattern.Match(header) {
+			return k.Name
+		}
+	}
+	return ""
+}
+
+// scanMode is the scoping mode for the language unless forced
+func (l *Language) scanMode(forced string) string {
+	switch {
+	case forced != "":
+		return forced
+	case l.Mode != "":
+		return l.Mode
+	}
+	return "delim"
+}
+
+// LookupLanguage finds a language by name
+func LookupLanguage(name string) (*Language, error) {
+	for _, l := range Languages {
+		if l.Name == name {
+			return l, nil
+		}
+	}
+	names := make([]string, 0, len(Languages))
+	for _, l := range Languages {
+		names = append(names, l.Name)
+	}
+	return nil, fmt.Errorf("unknown language %q, known: %s", name, strings.Join(names, ", "))
+}
+
+// DetectLanguage guesses the language from a file extension
+func DetectLanguage(path string) *Language {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, l := range Languages {
+		for _, e := range l.Exts {
+			if e == ext {
+				return l
+			}
+		}
+	}
+	return Generic
+}
@@ -0,0 +1,57 @@
+package sgrep
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// records have no delimiters of their own, the lines starting them open them
+var logOpen, logClose = &Delimiter{Str: "", Open: true}, &Delimiter{Str: "", Open: false}
+
+func init() {
+	logOpen.Pair, logClose.Pair = logClose, logOpen
+}
+
+// RecordStart matches the timestamps starting log records by default:
+// ISO 8601 and similar dates, syslog dates and bare times
+var RecordStart = regexp.MustCompile(`^\[?(\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}|[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}|\d{2}:\d{2}:\d{2})`)
+
+// logScanner makes a scope of each log record, from a line matching
+// Options.RecordStart to the last non blank line before the next one.
+// Continuation lines like stack traces belong to the record before them.
+type logScanner struct {
+	start Matcher
+	open  bool
+	last  *Line // last non blank line
+}
+
+func (s *logScanner) scan(l *Line) Markers {
+	var markers Markers
+	if s.start.FindAllIndex(l.content(), 1) != nil {
+		if s.open {
+			markers = append(markers, closeAt(logClose, s.last))
+		}
+		markers = append(markers, &Marker{Delim: logOpen, Line: l, Col: 0})
+		s.open = true
+	}
+	if len(bytes.TrimSpace(l.Text)) > 0 {
+		s.last = l
+	}
+	return markers
+}
+
+// lines after the last non blank one could still be in its record
+func (s *logScanner) settled() uint {
+	if s.last == nil {
+		return 0
+	}
+	return s.last.Num
+}
+
+func (s *logScanner) finish() Markers {
+	if !s.open {
+		return nil
+	}
+	s.open = false
+	return Markers{closeAt(logClose, s.last)}
+}
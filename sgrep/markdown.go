@@ -0,0 +1,111 @@
+package sgrep
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// sections have no delimiters of their own, their headings open them
+var mdOpen, mdClose = &Delimiter{Str: "", Open: true}, &Delimiter{Str: "", Open: false}
+
+func init() {
+	mdOpen.Pair, mdClose.Pair = mdClose, mdOpen
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*\r?\n?$`)
+	mdSetext  = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*\r?\n?$`)
+	mdFence   = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+)
+
+// mdSection is a section open, its heading level and title
+type mdSection struct {
+	level int
+	title string
+}
+
+// markdownScanner makes scopes out of the sections of a markdown document.
+// A section goes from its heading to the next heading of the same or a
+// higher level, so it contains its subsections. Headings are # style or
+// underlined with = or -, those in fenced code blocks don't count. Paths
+// are the titles of the section and those enclosing it.
+type markdownScanner struct {
+	open   []mdSection
+	fence  string // fence of the code block we're in
+	last   *Line  // last non blank line
+	before *Line  // non blank line before last
+	text   bool   // last is paragraph text, may get underlined
+	next   uint
+}
+
+func (s *markdownScanner) scan(l *Line) Markers {
+	s.next = l.Num
+	if len(bytes.TrimSpace(l.Text)) == 0 {
+		s.text = false
+		return nil
+	}
+	var markers Markers
+	switch m := mdFence.FindSubmatch(l.Text); {
+	case s.fence != "":
+		if m != nil && strings.HasPrefix(string(m[1]), s.fence) {
+			s.fence = ""
+		}
+		s.advance(l, false)
+	case m != nil:
+		s.fence = string(m[1])
+		s.advance(l, false)
+	case mdSetext.Match(l.Text) && s.text && s.last.Num+1 == l.Num:
+		level := 1
+		if bytes.Contains(l.Text, []byte("-")) {
+			level = 2
+		}
+		// the underlined line is the heading, it's been read already
+		markers = s.heading(s.last, s.before, level, string(bytes.TrimSpace(s.last.Text)))
+		s.advance(l, false)
+	default:
+		if m := mdHeading.FindSubmatch(l.Text); m != nil {
+			markers = s.heading(l, s.last, len(m[1]), string(m[2]))
+			s.advance(l, false)
+		} else {
+			s.advance(l, true)
+		}
+	}
+	return markers
+}
+
+func (s *markdownScanner) advance(l *Line, text bool) {
+	s.before, s.last, s.text = s.last, l, text
+}
+
+// heading closes the sections it ends at prev, the line before it, and
+// opens its own
+func (s *markdownScanner) heading(l, prev *Line, level int, title string) Markers {
+	var markers Markers
+	for len(s.open) > 0 && s.open[len(s.open)-1].level >= level {
+		markers = append(markers, closeAt(mdClose, prev))
+		s.open = s.open[:len(s.open)-1]
+	}
+	s.open = append(s.open, mdSection{level, title})
+	titles := make([]string, len(s.open))
+	for i, section := range s.open {
+		titles[i] = section.title
+	}
+	return append(markers, &Marker{Delim: mdOpen, Line: l, Col: 0, Path: strings.Join(titles, " > ")})
+}
+
+// closeAt closes a scope at the end of a line
+func closeAt(d *Delimiter, l *Line) *Marker {
+	return &Marker{Delim: d, Line: l, Col: uint(len(bytes.TrimRight(l.Text, " \t\r\n")))}
+}
+
+// the last line read may still turn out to be a heading
+func (s *markdownScanner) settled() uint { return s.next }
+
+func (s *markdownScanner) finish() Markers {
+	var markers Markers
+	for ; len(s.open) > 0; s.open = s.open[:len(s.open)-1] {
+		markers = append(markers, closeAt(mdClose, s.last))
+	}
+	return markers
+}
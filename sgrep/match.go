@@ -0,0 +1,69 @@
+package sgrep
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// Matcher finds the locations of a pattern in a line, *regexp.Regexp is one
+type Matcher interface {
+	// FindAllIndex returns up to n [start, end) locations, all if n < 0
+	FindAllIndex(b []byte, n int) [][]int
+}
+
+// Literal is a fixed string Matcher, no regexp overhead nor metacharacters
+type Literal []byte
+
+func (lit Literal) FindAllIndex(b []byte, n int) [][]int {
+	if len(lit) == 0 {
+		return [][]int{{0, 0}}
+	}
+	var locs [][]int
+	for pos := 0; n < 0 || len(locs) < n; {
+		i := bytes.Index(b[pos:], lit)
+		if i < 0 {
+			break
+		}
+		pos += i
+		locs = append(locs, []int{pos, pos + len(lit)})
+		pos += len(lit)
+	}
+	return locs
+}
+
+// required is a literal any match of m contains, nil if unknown. Lines
+// without it are skipped without running the matcher.
+func required(m Matcher) []byte {
+	switch m := m.(type) {
+	case Literal:
+		if len(m) > 0 {
+			return m
+		}
+	case words:
+		return required(m.m)
+	case *regexp.Regexp:
+		if prefix, _ := m.LiteralPrefix(); prefix != "" {
+			return []byte(prefix)
+		}
+	}
+	return nil
+}
+
+// Words only keeps the matches of m that aren't part of a longer word, for
+// regexps wrapping the pattern in \b is cheaper
+func Words(m Matcher) Matcher { return words{m} }
+
+type words struct{ m Matcher }
+
+func (w words) FindAllIndex(b []byte, n int) [][]int {
+	var locs [][]int
+	for _, loc := range w.m.FindAllIndex(b, -1) {
+		if n >= 0 && len(locs) >= n {
+			break
+		}
+		if loc[0] < loc[1] && wordBounded(b, loc[0], loc[1]) {
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
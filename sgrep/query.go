@@ -0,0 +1,255 @@
+package sgrep
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Query is a condition on scopes, combining their kind, depth and size
+// with the text within them, see ParseQuery
+type Query struct {
+	src  string
+	expr queryExpr
+}
+
+// queryExpr evaluates part of a query on a scope, lines being those of
+// the scope that are buffered
+type queryExpr func(s *Scope, lines []*Line) bool
+
+// ParseQuery parses queries like
+//
+//	kind=function AND contains(/TODO/) AND NOT contains(/test/) AND depth<=2
+//
+// Conditions are combined with AND, OR and NOT, grouped by parentheses.
+// They compare kind and path with = or !=, and depth, lines (spanned) and
+// matches (of the patterns) with =, !=, <, <=, > or >=. contains(/re/)
+// holds if a line of the scope matches re, header(/re/) if its opening
+// line does.
+func ParseQuery(src string) (*Query, error) {
+	p := &queryParser{src: src}
+	p.next()
+	expr, err := p.or()
+	if err == nil && p.tok != "" {
+		err = p.errorf("unexpected %q", p.tok)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Query{src, expr}, nil
+}
+
+func (q *Query) String() string { return q.src }
+
+// Match checks the query on the scope of a result
+func (q *Query) Match(r Result) bool {
+	s := r.Scope
+	lines := make([]*Line, 0, len(r.Lines))
+	for _, l := range r.Lines {
+		if l.Num >= s.Start.Line.Num && (s.End == nil || l.Num <= s.End.Line.Num) {
+			lines = append(lines, l)
+		}
+	}
+	return q.expr(s, lines)
+}
+
+// queryParser is a recursive descent parser of queries, tok is the token
+// at pos
+type queryParser struct {
+	src      string
+	pos, end int
+	tok      string
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("bad query at %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// next moves to the following token: a word, a "string", a /regexp/, an
+// operator or a parenthesis, empty at the end
+func (p *queryParser) next() {
+	p.pos = p.end
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+	p.end = p.pos
+	switch {
+	case p.end == len(p.src):
+	case p.src[p.end] == '/' || p.src[p.end] == '"':
+		for quote := p.src[p.end]; p.end+1 < len(p.src) && p.src[p.end+1] != quote; p.end++ {
+			if p.src[p.end+1] == '\\' {
+				p.end++
+			}
+		}
+		p.end = min(p.end+2, len(p.src))
+	case strings.IndexByte("<>!=", p.src[p.end]) >= 0:
+		p.end++
+		if p.end < len(p.src) && p.src[p.end] == '=' {
+			p.end++
+		}
+	case strings.IndexByte("(),", p.src[p.end]) >= 0:
+		p.end++
+	default:
+		for p.end < len(p.src) && strings.IndexByte(" \t<>!=(),/", p.src[p.end]) < 0 {
+			p.end++
+		}
+	}
+	p.tok = p.src[p.pos:p.end]
+}
+
+// keyword checks if the token is a keyword, in any case
+func (p *queryParser) keyword(k string) bool { return strings.EqualFold(p.tok, k) }
+
+func (p *queryParser) or() (queryExpr, error) {
+	left, err := p.and()
+	for err == nil && p.keyword("OR") {
+		p.next()
+		var right queryExpr
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(s *Scope, lines []*Line) bool { return l(s, lines) || right(s, lines) }
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) and() (queryExpr, error) {
+	left, err := p.not()
+	for err == nil && p.keyword("AND") {
+		p.next()
+		var right queryExpr
+		if right, err = p.not(); err == nil {
+			l := left
+			left = func(s *Scope, lines []*Line) bool { return l(s, lines) && right(s, lines) }
+		}
+	}
+	return left, err
+}
+
+func (p *queryParser) not() (queryExpr, error) {
+	if !p.keyword("NOT") {
+		return p.term()
+	}
+	p.next()
+	e, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	return func(s *Scope, lines []*Line) bool { return !e(s, lines) }, nil
+}
+
+func (p *queryParser) term() (queryExpr, error) {
+	switch name := strings.ToLower(p.tok); name {
+	case "":
+		return nil, p.errorf("missing condition")
+	case "(":
+		p.next()
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("missing )")
+		}
+		p.next()
+		return e, nil
+	case "contains", "header":
+		re, err := p.call()
+		if err != nil {
+			return nil, err
+		}
+		if name == "header" {
+			return func(s *Scope, lines []*Line) bool { return re.Match(s.Start.Line.content()) }, nil
+		}
+		return func(s *Scope, lines []*Line) bool {
+			for _, l := range lines {
+				if re.Match(l.content()) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "kind", "path":
+		p.next()
+		op := p.tok
+		if op != "=" && op != "!=" {
+			return nil, p.errorf("%s compares with = or !=", name)
+		}
+		p.next()
+		value := p.tok
+		if value == "" || strings.IndexByte("()", value[0]) >= 0 {
+			return nil, p.errorf("missing %s value", name)
+		}
+		if uq, err := strconv.Unquote(value); err == nil {
+			value = uq
+		}
+		p.next()
+		field := func(s *Scope) string { return s.Kind }
+		if name == "path" {
+			field = func(s *Scope) string { return s.Path }
+		}
+		return func(s *Scope, lines []*Line) bool { return (field(s) == value) == (op == "=") }, nil
+	case "depth", "lines", "matches":
+		p.next()
+		op := p.tok
+		cmp, ok := queryOps[op]
+		if !ok {
+			return nil, p.errorf("%s compares with =, !=, <, <=, > or >=", name)
+		}
+		p.next()
+		n, err := strconv.Atoi(p.tok)
+		if err != nil {
+			return nil, p.errorf("expected a number, got %q", p.tok)
+		}
+		p.next()
+		field := queryFields[name]
+		return func(s *Scope, lines []*Line) bool { return cmp(field(s), n) }, nil
+	default:
+		return nil, p.errorf("unknown condition %q", p.tok)
+	}
+}
+
+// call parses the (/re/) argument of contains and header
+func (p *queryParser) call() (*regexp.Regexp, error) {
+	p.next()
+	if p.tok != "(" {
+		return nil, p.errorf("missing (")
+	}
+	p.next()
+	if len(p.tok) < 2 || p.tok[0] != '/' || p.tok[len(p.tok)-1] != '/' {
+		return nil, p.errorf("expected a /regexp/, got %q", p.tok)
+	}
+	re, err := regexp.Compile(strings.ReplaceAll(p.tok[1:len(p.tok)-1], `\/`, "/"))
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	p.next()
+	if p.tok != ")" {
+		return nil, p.errorf("missing )")
+	}
+	p.next()
+	return re, nil
+}
+
+var queryOps = map[string]func(a, b int) bool{
+	"=":  func(a, b int) bool { return a == b },
+	"!=": func(a, b int) bool { return a != b },
+	"<":  func(a, b int) bool { return a < b },
+	"<=": func(a, b int) bool { return a <= b },
+	">":  func(a, b int) bool { return a > b },
+	">=": func(a, b int) bool { return a >= b },
+}
+
+// queryFields are the numeric fields of scopes, lines of an open scope
+// only count up to its start
+var queryFields = map[string]func(s *Scope) int{
+	"depth":   func(s *Scope) int { return s.depth },
+	"matches": func(s *Scope) int { return int(s.Total()) },
+	"lines": func(s *Scope) int {
+		if s.End == nil {
+			return 1
+		}
+		return int(s.End.Line.Num - s.Start.Line.Num + 1)
+	},
+}
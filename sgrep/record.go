@@ -0,0 +1,189 @@
+package sgrep
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// Recording is what a scanner found in an input: the markers returned
+// after each line and the regions of lines. Parsing the same input again
+// with Options.Replay skips scanning, only patterns are matched. It's gob
+// encoded to be stored.
+type Recording struct {
+	delims []recordedDelim
+	scans  []recordedScan // one per line, then the one from finish
+	ids    map[*Delimiter]int
+	done   bool // input finished, the recording is complete
+}
+
+type recordedDelim struct {
+	Str  string
+	Open bool
+	Pair int // index of the pair, -1 if none
+}
+
+type recordedScan struct {
+	Settled uint
+	Markers []recordedMarker
+	Regions []int // start, end and kind of each region of the line
+}
+
+type recordedMarker struct {
+	Delim      int
+	Line, Col  uint
+	Name, Path string
+}
+
+// the encoded form of a Recording
+type recording struct {
+	Delims []recordedDelim
+	Scans  []recordedScan
+}
+
+func (r *Recording) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(recording{r.delims, r.scans})
+	return buf.Bytes(), err
+}
+
+func (r *Recording) GobDecode(data []byte) error {
+	var rec recording
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rec); err != nil {
+		return err
+	}
+	r.delims, r.scans, r.done = rec.Delims, rec.Scans, true
+	return nil
+}
+
+// Lines tells how many lines of input were recorded
+func (r *Recording) Lines() int {
+	if len(r.scans) == 0 {
+		return 0
+	}
+	return len(r.scans) - 1
+}
+
+// delim numbers the delimiters as they're seen, their pairs too
+func (r *Recording) delim(d *Delimiter) int {
+	if id, ok := r.ids[d]; ok {
+		return id
+	}
+	id := len(r.delims)
+	r.ids[d] = id
+	r.delims = append(r.delims, recordedDelim{d.Str, d.Open, -1})
+	if d.Pair != nil {
+		r.delims[id].Pair = r.delim(d.Pair)
+	}
+	return id
+}
+
+func (r *Recording) add(l *Line, markers Markers, settled uint) {
+	scan := recordedScan{Settled: settled}
+	for _, m := range markers {
+		scan.Markers = append(scan.Markers, recordedMarker{r.delim(m.Delim), m.Line.Num, m.Col, m.Name, m.Path})
+	}
+	if l != nil {
+		for _, reg := range l.regions {
+			scan.Regions = append(scan.Regions, reg.start, reg.end, int(reg.kind))
+		}
+	}
+	r.scans = append(r.scans, scan)
+}
+
+// recorder keeps what a scanner finds in a Recording
+type recorder struct {
+	scanner
+	rec *Recording
+}
+
+func (r *recorder) scan(l *Line) Markers {
+	markers := r.scanner.scan(l)
+	r.rec.add(l, markers, r.scanner.settled())
+	return markers
+}
+
+func (r *recorder) finish() Markers {
+	markers := r.scanner.finish()
+	r.rec.add(nil, markers, 0)
+	r.rec.done = true
+	return markers
+}
+
+// replayer is a scanner returning the markers of a Recording. Lines are
+// kept while markers returned later are on them.
+type replayer struct {
+	rec    *Recording
+	delims []*Delimiter
+	next   int
+	lines  map[uint]*Line
+	refs   map[uint]int // markers on each line yet to return
+	settle uint
+}
+
+func newReplayer(rec *Recording) *replayer {
+	r := &replayer{rec: rec, lines: make(map[uint]*Line), refs: make(map[uint]int)}
+	r.delims = make([]*Delimiter, len(rec.delims))
+	for i, d := range rec.delims {
+		r.delims[i] = &Delimiter{Str: d.Str, Open: d.Open}
+	}
+	for i, d := range rec.delims {
+		if d.Pair >= 0 {
+			r.delims[i].Pair = r.delims[d.Pair]
+		}
+	}
+	for _, s := range rec.scans {
+		for _, m := range s.Markers {
+			r.refs[m.Line]++
+		}
+	}
+	return r
+}
+
+func (r *replayer) scan(l *Line) Markers {
+	if r.next >= r.rec.Lines() {
+		// input longer than recorded, nothing else to find
+		r.settle = l.Num + 1
+		return nil
+	}
+	s := r.rec.scans[r.next]
+	r.next++
+	for i := 0; i+2 < len(s.Regions); i += 3 {
+		l.regions = append(l.regions, region{start: s.Regions[i], end: s.Regions[i+1], kind: regionKind(s.Regions[i+2])})
+	}
+	if r.refs[l.Num] > 0 {
+		r.lines[l.Num] = l
+	}
+	r.settle = s.Settled
+	return r.markers(s)
+}
+
+func (r *replayer) markers(s recordedScan) Markers {
+	if len(s.Markers) == 0 {
+		return nil
+	}
+	found := make([]Marker, len(s.Markers))
+	markers := make(Markers, len(s.Markers))
+	n := 0
+	for _, m := range s.Markers {
+		line := r.lines[m.Line]
+		if r.refs[m.Line]--; r.refs[m.Line] == 0 {
+			delete(r.lines, m.Line)
+		}
+		if line == nil {
+			continue // input shorter than recorded
+		}
+		found[n] = Marker{Delim: r.delims[m.Delim], Line: line, Col: m.Col, Name: m.Name, Path: m.Path}
+		markers[n] = &found[n]
+		n++
+	}
+	return markers[:n]
+}
+
+func (r *replayer) settled() uint { return r.settle }
+
+func (r *replayer) finish() Markers {
+	if len(r.rec.scans) == 0 {
+		return nil
+	}
+	return r.markers(r.rec.scans[len(r.rec.scans)-1])
+}
@@ -0,0 +1,113 @@
+package sgrep
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// regexDelim is a pattern finding the delimiters of a pair
+type regexDelim struct {
+	re    *regexp.Regexp
+	delim *Delimiter
+}
+
+// regexScanner finds delimiters with regular expressions, see
+// Options.RegexDelims. Scopes close at the start of their end match. Where
+// both the begin and end of a pair match, like with ``` fences, a scope
+// of the pair closes if one is open or else opens. With flat nesting the
+// scopes of a pair don't nest, begins within them are ignored and so are
+// ends with nothing to close.
+type regexScanner struct {
+	tokens *tokenizer
+	delims []regexDelim
+	flat   bool
+	open   []*Delimiter // openers of the scopes open, innermost last
+	next   uint
+}
+
+// Nestings are how scopes of regexp delimiters nest, see Options.Nesting
+var Nestings = []string{"nested", "flat"}
+
+func newRegexScanner(lang *Language, opts *Options) (*regexScanner, error) {
+	s := &regexScanner{tokens: lang.tokenizer(opts)}
+	switch opts.Nesting {
+	case "", "nested":
+	case "flat":
+		s.flat = true
+	default:
+		return nil, fmt.Errorf("unknown nesting %q, known: %s", opts.Nesting, strings.Join(Nestings, ", "))
+	}
+	// pairs sharing an end share its delimiter, like in delimSet
+	ends := make(map[string]*Delimiter)
+	for _, pair := range opts.RegexDelims {
+		var res [2]*regexp.Regexp
+		for i, expr := range pair {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("bad delimiter: %v", err)
+			}
+			res[i] = re
+		}
+		open := &Delimiter{Str: pair[0], Open: true}
+		close, ok := ends[pair[1]]
+		if !ok {
+			close = &Delimiter{Str: pair[1], Pair: open}
+			ends[pair[1]] = close
+			s.delims = append(s.delims, regexDelim{res[1], close})
+		}
+		open.Pair = close
+		s.delims = append(s.delims, regexDelim{res[0], open})
+	}
+	return s, nil
+}
+
+func (s *regexScanner) scan(l *Line) Markers {
+	s.next = l.Num + 1
+	l.regions = s.tokens.regions(l.Text)
+	var markers Markers
+	for _, d := range s.delims {
+		for _, loc := range d.re.FindAllIndex(l.Text, -1) {
+			markers = append(markers, &Marker{Delim: d.delim, Line: l, Col: uint(loc[0])})
+		}
+	}
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].Col < markers[j].Col })
+	out := markers[:0]
+	for _, m := range markers {
+		// a spot matching both ends of a pair closes it if open
+		toggle := false
+		for _, other := range markers {
+			if other.Col == m.Col && other.Delim == m.Delim.Pair {
+				toggle = true
+			}
+		}
+		open := s.opened(m.Delim)
+		switch {
+		case toggle && m.Delim.Open == (open >= 0):
+			continue
+		case s.flat && m.Delim.Open == (open >= 0):
+			// begins within a scope of the pair and the ends they'd need
+			continue
+		case m.Delim.Open:
+			s.open = append(s.open, m.Delim)
+		case open >= 0:
+			s.open = s.open[:open]
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// opened finds the innermost scope open of a delimiter's pair, -1 if none
+func (s *regexScanner) opened(d *Delimiter) int {
+	for i := len(s.open) - 1; i >= 0; i-- {
+		if s.open[i] == d || s.open[i].Pair == d {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *regexScanner) settled() uint   { return s.next }
+func (s *regexScanner) finish() Markers { return nil }
@@ -0,0 +1,229 @@
+package sgrep
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// scanner finds the scope markers in each line of input
+type scanner interface {
+	// scan returns the markers found after reading l. Depending on the
+	// scanner they may belong to earlier lines.
+	scan(l *Line) Markers
+	// settled is the first line which may still get new markers
+	settled() uint
+	// finish returns the markers closing scopes at the end of input
+	finish() Markers
+}
+
+// Modes are the ways scopes can be defined
+var Modes = []string{"delim", "indent", "xml", "json", "yaml", "markdown", "logs"}
+
+// Engines are the parsers available to find scopes, heuristic is the
+// default. Some are only compiled in with build tags.
+var Engines = []string{"heuristic"}
+
+// engines create the scanners of optional parsers by name
+var engines = map[string]func(lang *Language, opts *Options) (scanner, error){}
+
+// newScanner creates the scanner for the engine or the language's mode
+func newScanner(lang *Language, opts *Options) (scanner, error) {
+	if opts.Engine != "" && opts.Engine != "heuristic" {
+		engine, ok := engines[opts.Engine]
+		if !ok {
+			return nil, fmt.Errorf("unknown engine %q, known: %s (others need build tags)",
+				opts.Engine, strings.Join(Engines, ", "))
+		}
+		return engine(lang, opts)
+	}
+	if len(opts.RegexDelims) > 0 {
+		return newRegexScanner(lang, opts)
+	}
+	switch m := lang.scanMode(opts.Mode); m {
+	case "delim":
+		s := &delimScanner{tokens: lang.tokenizer(opts), delims: lang.delimSet(opts).index(),
+			escapes: lang.Escapes && !opts.NoEscapes}
+		if lang.Keywords {
+			s.keywords = make(map[string]bool)
+			for _, k := range lang.Statements {
+				s.keywords[k] = true
+			}
+		}
+		if len(opts.Delims) == 0 {
+			s.notDelims = lang.NotDelims
+		}
+		if lang.Terminator != "" && len(opts.Delims) == 0 {
+			s.stmts = newStatements(lang.Terminator, lang.Blocks)
+			first := lang.Terminator[0]
+			s.delims[first] = append(s.delims[first], s.stmts.close)
+		}
+		if lang.CaseArms && len(opts.Delims) == 0 {
+			s.arms = &caseArms{}
+			s.delims[';'] = append(s.delims[';'], armClose)
+		}
+		s.firsts = s.delims.firsts()
+		return s, nil
+	case "indent":
+		return &indentScanner{comments: lang.LineComments, tokens: lang.tokenizer(opts)}, nil
+	case "xml":
+		return &xmlScanner{}, nil
+	case "json":
+		return &jsonScanner{}, nil
+	case "yaml":
+		return &indentScanner{comments: lang.LineComments, tokens: lang.tokenizer(opts), yaml: true}, nil
+	case "markdown":
+		return &markdownScanner{}, nil
+	case "logs":
+		start := opts.RecordStart
+		if start == nil {
+			start = RecordStart
+		}
+		return &logScanner{start: start}, nil
+	default:
+		return nil, fmt.Errorf("unknown mode %q, known: %s", m, strings.Join(Modes, ", "))
+	}
+}
+
+// delimScanner finds opening and closing delimiters outside of comments
+// and string literals
+type delimScanner struct {
+	tokens  *tokenizer
+	delims  *delimIndex
+	escapes bool      // skip delimiters escaped with a backslash
+	arms    *caseArms // for languages with CaseArms
+	// for languages with Keywords, true for the Statements
+	keywords  map[string]bool
+	notDelims *regexp.Regexp // see Language.NotDelims
+	stmts     *statements    // for languages with a Terminator
+	firsts    string         // see delimIndex.firsts
+	next      uint
+}
+
+func (s *delimScanner) scan(l *Line) Markers {
+	s.next = l.Num + 1
+	l.regions = s.tokens.regions(l.Text)
+	var markers Markers
+	// most lines of logs and prose have no delimiters at all
+	if s.firsts == "" || bytes.IndexAny(l.Text, s.firsts) >= 0 {
+		markers = l.findMarkers(l.regions, s.delims, s.escapes)
+	}
+	if s.notDelims != nil {
+		markers = s.dropNotDelims(l.Text, markers)
+	}
+	if s.keywords != nil {
+		markers = s.keywordMarkers(l.Text, markers)
+	}
+	if s.stmts != nil {
+		markers = s.stmts.scan(l, markers)
+	}
+	if s.arms != nil {
+		markers = s.arms.scan(markers)
+	}
+	return markers
+}
+
+// dropNotDelims drops the markers where Language.NotDelims match
+func (s *delimScanner) dropNotDelims(line []byte, markers Markers) Markers {
+	out := markers[:0]
+	for _, m := range markers {
+		if !s.notDelims.Match(line[m.Col:]) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// keywordMarkers drops the word delimiters used as method names, symbols or
+// keys, like x.end, :end or end:, and statement openers used as modifiers
+func (s *delimScanner) keywordMarkers(line []byte, markers Markers) Markers {
+	out := markers[:0]
+	for _, m := range markers {
+		start, end := int(m.Col), int(m.Col)+len(m.Delim.Str)
+		if isWordByte(m.Delim.Str[0]) {
+			if start > 0 && (line[start-1] == '.' || line[start-1] == ':') ||
+				end < len(line) && line[end] == ':' && (end+1 == len(line) || line[end+1] != ':') {
+				continue
+			}
+			if m.Delim.Open && s.keywords[m.Delim.Str] && !statementStart(line[:start]) {
+				continue
+			}
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// statementStart checks if what comes before a keyword lets it start a
+// statement, as in `x = if y`
+func statementStart(before []byte) bool {
+	before = bytes.TrimRight(before, " \t")
+	return len(before) == 0 || bytes.IndexByte([]byte("=(;|&!{[,"), before[len(before)-1]) >= 0
+}
+
+// the arms of a shell case open with the ) ending their pattern and close
+// with ;; or at esac
+var armOpen, armClose = &Delimiter{Str: ")", Open: true}, &Delimiter{Str: ";;", Open: false}
+
+func init() {
+	armOpen.Pair, armClose.Pair = armClose, armOpen
+}
+
+// caseArms turns the unpaired ) of case patterns into arm openers
+type caseArms struct {
+	cases []caseState // open case statements, innermost last
+}
+
+type caseState struct {
+	parens int  // ( opened within the case, their ) aren't patterns
+	arm    bool // an arm is open
+}
+
+func (a *caseArms) scan(markers Markers) Markers {
+	out := markers[:0:0]
+	for _, m := range markers {
+		var c *caseState
+		if len(a.cases) > 0 {
+			c = &a.cases[len(a.cases)-1]
+		}
+		switch {
+		case m.Delim.Str == "case" && m.Delim.Open:
+			a.cases = append(a.cases, caseState{})
+		case c == nil:
+			if m.Delim == armClose {
+				continue // ;; out of a case
+			}
+		case m.Delim.Str == "(" && m.Delim.Open:
+			c.parens++
+		case m.Delim.Str == ")" && !m.Delim.Open:
+			if c.parens > 0 {
+				c.parens--
+			} else if !c.arm {
+				c.arm = true
+				m = &Marker{Delim: armOpen, Line: m.Line, Col: m.Col}
+			}
+		case m.Delim == armClose:
+			if !c.arm {
+				continue
+			}
+			c.arm = false
+		case m.Delim.Str == "esac" && !m.Delim.Open:
+			if c.arm {
+				out = append(out, &Marker{Delim: armClose, Line: m.Line, Col: m.Col})
+			}
+			a.cases = a.cases[:len(a.cases)-1]
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func (s *delimScanner) settled() uint { return s.next }
+
+func (s *delimScanner) finish() Markers {
+	if s.stmts != nil {
+		return s.stmts.finish()
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package sgrep
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Delimiter is a token opening or closing a scope
+type Delimiter struct {
+	Str  string
+	Open bool
+	// opposite delimiter closing or opening the scope, closers shared by
+	// several openers, like end in ruby, pair with the first one
+	Pair *Delimiter
+	Fold bool // letters match in any case, sql style
+}
+
+// at checks for the delimiter at the start of text
+func (d *Delimiter) at(text []byte) bool {
+	if d.Fold {
+		return len(text) >= len(d.Str) && bytes.EqualFold(text[:len(d.Str)], []byte(d.Str))
+	}
+	return bytes.HasPrefix(text, []byte(d.Str))
+}
+
+// delimSet has the delimiters by their text
+type delimSet map[string]*Delimiter
+
+func (d delimSet) add(pairs [][2]string) {
+	for _, p := range pairs {
+		open := &Delimiter{Str: p[0], Open: true}
+		close, ok := d[p[1]]
+		if !ok || close.Open {
+			close = &Delimiter{Str: p[1], Open: false, Pair: open}
+			d[p[1]] = close
+		}
+		open.Pair = close
+		d[p[0]] = open
+	}
+}
+
+// delimIndex lists the delimiters by their first byte, longest first.
+// Those matching in any case are under both.
+type delimIndex [256][]*Delimiter
+
+func (d delimSet) index() *delimIndex {
+	idx := new(delimIndex)
+	for _, delim := range d {
+		first := delim.Str[0]
+		idx[first] = append(idx[first], delim)
+		if other := swapCase(first); delim.Fold && other != first {
+			idx[other] = append(idx[other], delim)
+		}
+	}
+	for _, list := range idx {
+		sort.Slice(list, func(i, j int) bool { return len(list[i].Str) > len(list[j].Str) })
+	}
+	return idx
+}
+
+// firsts are the bytes delimiters start with, for bytes.IndexAny to
+// tell lines without any. Empty if some aren't ascii, IndexAny would
+// take them as runes.
+func (idx *delimIndex) firsts() string {
+	var b []byte
+	for c, list := range idx {
+		if len(list) == 0 {
+			continue
+		}
+		if c >= 0x80 {
+			return ""
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+
+// Line of input, Num is 0-based
+type Line struct {
+	Text    []byte
+	Num     uint
+	regions []region // code, literals and comments, if the scanner knows
+}
+
+// content is the text of a line without its terminator, \n or \r\n, what
+// patterns are matched against so $ anchors at the end of lines
+func (l *Line) content() []byte { return bytes.TrimRight(l.Text, "\r\n") }
+
+// Marker is a delimiter found in a line
+type Marker struct {
+	Delim *Delimiter
+	Line  *Line
+	Col   uint
+	Name  string // tag name, closers only match openers with the same name
+	Path  string // where an opener is within a document, ie: .spec.containers[2] or Usage > Flags
+}
+
+type Markers []*Marker
+
+func (m Markers) Len() int      { return len(m) }
+func (m Markers) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m Markers) Less(i, j int) bool {
+	return (m[i].Line.Num < m[j].Line.Num) ||
+		(m[i].Line.Num == m[j].Line.Num && m[i].Col < m[j].Col)
+}
+
+// findMarkers scans the line once, left to right, so markers come out in
+// order. Delimiters overlapping at a column are all found, longest first.
+// The markers of a line share a single allocation. With escapes,
+// delimiters after an odd number of backslashes are skipped.
+func (l *Line) findMarkers(regions []region, delims *delimIndex, escapes bool) Markers {
+	var found []Marker
+	for col, c := range l.Text {
+		for _, d := range delims[c] {
+			end := col + len(d.Str)
+			if escapes && escaped(l.Text, col) {
+				break
+			}
+			if d.at(l.Text[col:]) && wordBounded(l.Text, col, end) &&
+				(inCode(regions, col) || atCommentEdge(regions, col, d.Str)) {
+				found = append(found, Marker{Delim: d, Line: l, Col: uint(col)})
+			}
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	markers := make(Markers, len(found))
+	for i := range found {
+		markers[i] = &found[i]
+	}
+	return markers
+}
+
+// escaped checks for an odd number of backslashes before col
+func escaped(line []byte, col int) bool {
+	n := 0
+	for col-n > 0 && line[col-n-1] == '\\' {
+		n++
+	}
+	return n%2 == 1
+}
+
+// word-like delimiters (begin, end) only match whole words
+func wordBounded(line []byte, start, end int) bool {
+	if isWordByte(line[start]) && start > 0 && isWordByte(line[start-1]) {
+		return false
+	}
+	if isWordByte(line[end-1]) && end < len(line) && isWordByte(line[end]) {
+		return false
+	}
+	return true
+}
+
+func swapCase(c byte) byte {
+	switch {
+	case 'a' <= c && c <= 'z':
+		return c - 'a' + 'A'
+	case 'A' <= c && c <= 'Z':
+		return c - 'A' + 'a'
+	}
+	return c
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// Scope is the region between an opening and a closing marker
+type Scope struct {
+	Parent *Scope // scope containing this one
+	Childs []*Scope
+	Start  *Marker
+	End    *Marker // nil while the scope is open
+	Match  bool    // scope contains a match, so it needs to be printed
+	Count  uint    // matches whose tightest scope is this one
+	Kind   string  // function, class, loop... from the language Kinds
+	Path   string  // within a document, json, yaml and markdown modes only
+	// bitmap of the patterns matched within the N levels marked
+	Patterns uint64
+	depth    int
+	window   bool // Options.From matched within, Until didn't yet
+}
+
+func (s *Scope) String() string {
+	if s.End != nil {
+		return fmt.Sprintf("%v:%v - %v:%v",
+			s.Start.Line.Num, s.Start.Col,
+			s.End.Line.Num, s.End.Col)
+	}
+	return fmt.Sprintf("%v:%v - *", s.Start.Line.Num, s.Start.Col)
+}
+
+// Total counts the matches in the scope and all its children
+func (s *Scope) Total() uint {
+	n := s.Count
+	for _, c := range s.Childs {
+		n += c.Total()
+	}
+	return n
+}
+
+// Union is the bitmap of patterns matched in the scope or its children
+func (s *Scope) Union() uint64 {
+	bits := s.Patterns
+	for _, c := range s.Childs {
+		bits |= c.Union()
+	}
+	return bits
+}
+
+// Depth is the number of scopes enclosing this one
+func (s *Scope) Depth() int { return s.depth }
+
+// Headers are the opening lines of the enclosing scopes, outermost first.
+// Scopes opening on the same line share a header.
+func (s *Scope) Headers() []*Line {
+	var lines []*Line
+	for p := s.Parent; p != nil; p = p.Parent {
+		if len(lines) > 0 && lines[0] == p.Start.Line {
+			continue
+		}
+		lines = append([]*Line{p.Start.Line}, lines...)
+	}
+	return lines
+}
+
+// Siblings are the other scopes in the same parent, in input order
+func (s *Scope) Siblings() []*Scope {
+	if s.Parent == nil {
+		return nil
+	}
+	var sibs []*Scope
+	for _, c := range s.Parent.Childs {
+		if c != s {
+			sibs = append(sibs, c)
+		}
+	}
+	return sibs
+}
+
+// Contains checks if the span from col0 to col1 in line is in the scope
+func (s *Scope) Contains(line, col0, col1 uint) bool {
+	return ((s.Start.Line.Num < line || (s.Start.Line.Num == line && s.Start.Col <= col0)) &&
+		(s.End == nil ||
+			(s.End.Line.Num > line || (s.End.Line.Num == line && s.End.Col >= col1))))
+}
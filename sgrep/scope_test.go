@@ -0,0 +1,124 @@
+package sgrep
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// findMarkersPerDelim is how markers were found before findMarkers, a
+// bytes.Index loop per delimiter and a sort, kept to compare with
+func (l *Line) findMarkersPerDelim(regions []region, delims delimSet) Markers {
+	markers := make(Markers, 0, 4)
+	for _, d := range delims {
+		for base := 0; base < len(l.Text); {
+			idx := bytes.Index(l.Text[base:], []byte(d.Str))
+			if idx < 0 {
+				break
+			}
+			col := base + idx
+			if wordBounded(l.Text, col, col+len(d.Str)) &&
+				(inCode(regions, col) || atCommentEdge(regions, col, d.Str)) {
+				markers = append(markers, &Marker{Delim: d, Line: l, Col: uint(col)})
+			}
+			base = col + 1
+		}
+	}
+	sort.Sort(markers)
+	return markers
+}
+
+func BenchmarkFindMarkers(b *testing.B) {
+	lang, _ := LookupLanguage("go")
+	opts := &Options{}
+	set, tokens := lang.delimSet(opts), lang.tokenizer(opts)
+	idx := set.index()
+	var lines []*Line
+	for i, text := range goSource(1000) {
+		l := &Line{Text: []byte(text), Num: uint(i)}
+		l.regions = tokens.regions(l.Text)
+		lines = append(lines, l)
+	}
+	b.Run("single-pass", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, l := range lines {
+				l.findMarkers(l.regions, idx, false)
+			}
+		}
+	})
+	b.Run("per-delimiter", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, l := range lines {
+				l.findMarkersPerDelim(l.regions, set)
+			}
+		}
+	})
+}
+
+func BenchmarkDelimScanner(b *testing.B) {
+	lang, _ := LookupLanguage("go")
+	for _, input := range []struct {
+		name  string
+		lines []string
+	}{{"code", goSource(1000)}, {"prose", prose(1000)}} {
+		var lines []*Line
+		for i, text := range input.lines {
+			lines = append(lines, &Line{Text: []byte(text), Num: uint(i)})
+		}
+		for _, prefilter := range []bool{true, false} {
+			name := input.name + "/prefilter"
+			if !prefilter {
+				name = input.name + "/no-prefilter"
+			}
+			b.Run(name, func(b *testing.B) {
+				sc, err := newScanner(lang, &Options{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				s := sc.(*delimScanner)
+				if !prefilter {
+					s.firsts = ""
+				}
+				for i := 0; i < b.N; i++ {
+					for _, l := range lines {
+						s.scan(l)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestFindMarkers(t *testing.T) {
+	tests := []struct {
+		lang    string
+		line    string
+		escapes bool
+		want    string
+	}{
+		{"go", "func f(a []int) {\n", false, "6( 9[ 10] 14) 16{"},
+		{"go", "s := \"{\" + `(` // {\n", false, ""},
+		{"go", "m[k] = f(x) /* { */ }\n", false, "1[ 3] 8( 10) 20}"},
+		{"sql", "BEGIN select 1; End\n", false, "0begin 16end"},
+		{"sql", "beginning ending\n", false, ""},
+		{"generic", "a \\{ b \\\\{ c }\n", true, "9{ 13}"},
+		{"generic", "a \\{ b }\n", false, "3{ 7}"},
+	}
+	for _, tc := range tests {
+		lang, err := LookupLanguage(tc.lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := &Options{}
+		l := &Line{Text: []byte(tc.line)}
+		var got []string
+		for _, m := range l.findMarkers(lang.tokenizer(opts).regions(l.Text), lang.delimSet(opts).index(), tc.escapes) {
+			got = append(got, fmt.Sprintf("%d%s", m.Col, m.Delim.Str))
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("%s %q: got %q, want %q", tc.lang, tc.line, strings.Join(got, " "), tc.want)
+		}
+	}
+}
@@ -0,0 +1,959 @@
+// Package sgrep is a scoped grep: it finds the scopes (brace blocks,
+// indented blocks, tags...) enclosing the matches of a regular expression.
+package sgrep
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Options control how scopes are found and which ones are reported
+type Options struct {
+	Scopes        uint        // enclosing scopes marked per match, 1 if 0
+	Ancestor      uint        // mark only the N-th enclosing scope instead, 1 the tightest
+	Language      *Language   // Generic if nil
+	Mode          string      // scoping mode, the language's if empty
+	Engine        string      // parser finding scopes, builtin heuristics if empty
+	Delims        [][2]string // custom delimiter pairs replacing the language ones
+	RegexDelims   [][2]string // begin/end regexp pairs replacing any other delimiters
+	Nesting       string      // if scopes of RegexDelims nest or not: nested or flat
+	NoLiterals    bool        // don't skip delimiters inside string literals
+	NoEscapes     bool        // don't skip delimiters escaped with a backslash
+	CommentScopes bool        // block comments are scopes too
+	Invert        bool        // report the scopes without any match instead
+	All           bool        // scopes must match every pattern, not any
+	Head          Matcher     // only scopes whose opening line matches, if set
+	From, Until   Matcher     // only matches between lines matching these in a scope, if From is set
+	RecordStart   Matcher     // lines starting log records, RecordStart if nil
+	MinDepth      int         // only scopes nested at least this deep, top level is 0
+	DepthLimit    int         // only scopes nested less than this deep, if > 0
+	MaxNesting    int         // scopes open at once, deeper delimiters are text, if > 0
+	Kind          string      // only scopes of this kind, if set
+	Unscoped      bool        // report matches out of any scope too, their line as a scope
+	MinSpan       int         // only report scopes spanning at least this many lines
+	MaxSpan       int         // only report scopes spanning at most this many lines, if > 0
+	Query         *Query      // only scopes the query holds for, any scope without patterns
+	Region        string      // only matches within code, comments or strings, if set
+	MatchOn       string      // matches count on a scope's header, body or anywhere if empty
+	Multiline     bool        // match across the lines of top level scopes, not line by line
+	MaxLines      int         // lines buffered for open scopes before eliding, 0 unlimited
+	MaxBytes      int         // bytes buffered for open scopes before eliding, 0 unlimited
+	Before, After uint        // context lines reported around each scope
+	Strict        bool        // collect unpaired delimiters, see Parser.Problems
+	Outline       bool        // report every top level scope, matching or not
+	Keep          string      // which matched scopes to report: broadest, tightest or all
+	AtLine        uint        // 1-based line whose scopes match instead of patterns, if set
+	AtCol         uint        // 1-based column in AtLine, its first non blank if 0
+	Record        bool        // keep what's scanned, see Parser.Recording
+	Replay        *Recording  // markers of the same input to use instead of scanning, if set
+
+	Name     string        // of the input, for errors
+	Warnings chan<- *Error // unpaired delimiters are sent here as found if set, dropped if that would block
+}
+
+// MaxPatterns is the limit of patterns a parser can look for
+const MaxPatterns = 64
+
+// Result is a matched scope along with the input it spans
+type Result struct {
+	Scope   *Scope
+	Lines   []*Line          // lines from the start to the end of the scope plus context, see Elided
+	Matches map[uint][][]int // location of the matches in each matching line
+}
+
+// Elided checks if lines without matches were dropped from a scope too big
+// to buffer, they show up as gaps in line numbers
+func (r *Result) Elided() bool {
+	return len(r.Lines) > 0 && r.Lines[len(r.Lines)-1].Num-r.Lines[0].Num+1 > uint(len(r.Lines))
+}
+
+// Parser reads input line by line building the scope tree and reporting
+// the scopes containing matches as soon as they are complete. A Parser
+// handles a single input, Reset starts another one. It isn't safe for
+// concurrent use, each goroutine needs its own.
+type Parser struct {
+	opts     Options
+	lang     *Language
+	patterns []Matcher
+	required [][]byte         // literals in every match of each pattern, see required
+	open     []*Scope         // currently open scopes, last is tightest
+	closed   []*Scope         // closed scopes, first is tightest, last is broadest
+	scanner  scanner          // finds scope markers on each line
+	pending  []*Line          // lines waiting for markers to settle before matching
+	buffer   map[uint]*Line   // lines from low on that may still be printed
+	matches  map[uint][][]int // all matches of any pattern in a line, in order
+	size     int              // bytes in buffer
+	low      uint             // lines before low were dropped from buffer
+	elided   uint             // lines before elided only kept if needed
+	held     []Result         // results waiting for their trailing context
+	last     *Scope           // last scope opened
+	deep     int              // openers past MaxNesting yet to close
+	window   bool             // From matched out of any scope, see Options.From
+	lineno   uint
+	scopes   []Scope // block new scopes are taken from, see allocBlock
+	problems []Problem
+	stats    Stats
+	rec      *Recording // with Options.Record
+}
+
+// Stats describe the work done by a parser so far
+type Stats struct {
+	Lines      uint // lines read
+	Opened     uint // scopes opened
+	Closed     uint // scopes closed
+	MaxDepth   uint // deepest nesting of open scopes
+	Unclosed   uint // scopes still open, unclosed at the end of input
+	Unexpected uint // closing delimiters without an opener, dropped
+	TooDeep    uint // opening delimiters past Options.MaxNesting, taken as text
+	Matches    uint // matches of any pattern
+	Buffered   int  // most bytes buffered at once
+}
+
+// Stats returns counters on the input read so far
+func (p *Parser) Stats() Stats {
+	stats := p.stats
+	stats.Lines, stats.Unclosed = p.lineno, uint(len(p.open))
+	return stats
+}
+
+// recoverDepth is how many open scopes a closing delimiter may skip to
+// find its opener. The skipped ones are taken as closed there, the
+// closer is dropped if there's no opener that close.
+const recoverDepth = 3
+
+// Recording returns what was scanned with Options.Record, nil until the
+// parser is closed
+func (p *Parser) Recording() *Recording {
+	if p.rec == nil || !p.rec.done {
+		return nil
+	}
+	return p.rec
+}
+
+// Problem is a delimiter left unpaired, found with Options.Strict
+type Problem struct {
+	Marker *Marker
+	Msg    string
+}
+
+// Problems returns the problems found since the last call
+func (p *Parser) Problems() []Problem {
+	problems := p.problems
+	p.problems = nil
+	return problems
+}
+
+func (p *Parser) problem(m *Marker, format string) {
+	if !p.opts.Strict && p.opts.Warnings == nil {
+		return
+	}
+	msg := fmt.Sprintf(format, m.Delim.Str+m.Name)
+	if p.opts.Strict {
+		p.problems = append(p.problems, Problem{m, msg})
+	}
+	// parsing never waits on a reader of warnings that may be gone
+	select {
+	case p.opts.Warnings <- &Error{File: p.opts.Name, Line: m.Line.Num + 1, Col: m.Col + 1,
+		Kind: UnbalancedError, Err: errors.New(msg)}:
+	default:
+	}
+}
+
+// allocBlock is how many scopes are allocated at once. Results hold on to
+// them so they can't be reused, but fewer and larger allocations ease the
+// GC on big inputs. A block lives while any of its scopes is referenced,
+// scopes are few next to lines and a result keeps its whole tree anyway.
+const allocBlock = 256
+
+// newLine allocates lines one by one, a line in a block would keep the
+// text of every other alive, the ones elided from big scopes too
+func (p *Parser) newLine(text []byte, num uint) *Line {
+	return &Line{Text: text, Num: num}
+}
+
+func (p *Parser) newScope(start *Marker) *Scope {
+	if len(p.scopes) == cap(p.scopes) {
+		p.scopes = make([]Scope, 0, allocBlock)
+	}
+	p.scopes = append(p.scopes, Scope{Start: start})
+	return &p.scopes[len(p.scopes)-1]
+}
+
+// NewParser creates a parser looking for any of the patterns, or all of
+// them with Options.All
+func NewParser(opts Options, patterns ...Matcher) (*Parser, error) {
+	if len(patterns) > MaxPatterns {
+		return nil, errors.New("too many patterns")
+	}
+	if opts.Scopes == 0 {
+		opts.Scopes = 1
+	}
+	switch opts.MatchOn {
+	case "", "anywhere", "header", "body":
+	default:
+		return nil, fmt.Errorf("unknown --match-on %q, known: header, body, anywhere", opts.MatchOn)
+	}
+	switch opts.Keep {
+	case "", "broadest", "tightest", "all":
+	default:
+		return nil, fmt.Errorf("unknown --keep %q, known: broadest, tightest, all", opts.Keep)
+	}
+	if _, ok := regionKinds[opts.Region]; !ok && opts.Region != "" {
+		return nil, fmt.Errorf("unknown region %q, known: %s", opts.Region, strings.Join(Regions, ", "))
+	}
+	p := &Parser{opts: opts, patterns: patterns,
+		buffer:  make(map[uint]*Line),
+		matches: make(map[uint][][]int)}
+	if err := p.start(opts.Language); err != nil {
+		return nil, err
+	}
+	for _, re := range patterns {
+		p.required = append(p.required, required(re))
+	}
+	return p, nil
+}
+
+// start sets up the scanner for an input in lang, Generic if nil
+func (p *Parser) start(lang *Language) error {
+	if lang == nil {
+		lang = Generic
+	}
+	scanner, err := newScanner(lang, &p.opts)
+	if err != nil {
+		return err
+	}
+	p.rec = nil
+	if p.opts.Replay != nil {
+		scanner = newReplayer(p.opts.Replay)
+	} else if p.opts.Record {
+		p.rec = &Recording{ids: make(map[*Delimiter]int)}
+		scanner = &recorder{scanner, p.rec}
+	}
+	p.lang, p.opts.Language, p.scanner = lang, lang, scanner
+	return nil
+}
+
+// Reset readies the parser for another input, in lang. Buffers are
+// reused but scopes come from a new block, the results of the previous
+// input stay valid. A Recording replayed only applies to the first input.
+func (p *Parser) Reset(lang *Language) error {
+	p.opts.Replay = nil
+	if err := p.start(lang); err != nil {
+		return err
+	}
+	p.open, p.closed, p.pending = p.open[:0], p.closed[:0], p.pending[:0]
+	p.held, p.problems = p.held[:0], nil
+	clear(p.buffer)
+	clear(p.matches)
+	p.size, p.low, p.elided, p.lineno, p.deep = 0, 0, 0, 0, 0
+	p.last, p.stats, p.window = nil, Stats{}, false
+	// results of the previous input point into the block
+	p.scopes = nil
+	return nil
+}
+
+// Search reads all of r returning the scopes containing matches of re
+func Search(r io.Reader, re Matcher, opts Options) ([]Result, error) {
+	return SearchContext(context.Background(), r, re, opts)
+}
+
+// SearchContext is Search stopping when ctx is done, returning the scopes
+// found until then along with the context's error. Errors reading r are
+// returned as an *Error, with the scopes found before them.
+func SearchContext(ctx context.Context, r io.Reader, re Matcher, opts Options) ([]Result, error) {
+	p, err := NewParser(opts, re)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	in := bufio.NewReader(r)
+	for {
+		line, err := in.ReadBytes('\n')
+		if len(line) > 0 {
+			results = append(results, p.Feed(line)...)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return results, inputError(err, opts.Name, p.lineno+1)
+		}
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+	}
+	return append(results, p.Close()...), nil
+}
+
+// Feed parses the next line of input, including its line terminator.
+// The parser keeps a reference to line. Returns the matched scopes that
+// are complete.
+func (p *Parser) Feed(text []byte) []Result {
+	line := p.newLine(text, p.lineno)
+	p.lineno++
+	found_markers := p.addMarkers(p.scanner.scan(line))
+	// keep buffer of lines if there's an open scope or one may open
+	if len(p.open) > 0 || found_markers || line.Num >= p.scanner.settled() ||
+		p.opts.Before > 0 || p.opts.After > 0 {
+		p.bufferLine(line)
+	}
+	p.pending = append(p.pending, line)
+	p.matchSettled(p.scanner.settled())
+	var results []Result
+	if len(p.open) == 0 {
+		if p.opts.Multiline {
+			p.matchScopes()
+		}
+		results = p.flushMatching(false)
+	}
+	results = p.trailing(line, results)
+	p.prune()
+	// multiline matches are only known once scopes close, nothing to elide
+	if !p.opts.Multiline && (p.opts.MaxLines > 0 && len(p.buffer) > p.opts.MaxLines ||
+		p.opts.MaxBytes > 0 && p.size > p.opts.MaxBytes) {
+		p.elide()
+	}
+	return results
+}
+
+// Close finishes the input, returning the remaining matched scopes
+func (p *Parser) Close() []Result {
+	p.addMarkers(p.scanner.finish())
+	for _, s := range p.open {
+		p.problem(s.Start, "unclosed %q")
+	}
+	p.matchSettled(p.lineno)
+	if p.opts.Multiline {
+		p.matchScopes()
+	}
+	return p.trailing(nil, p.flushMatching(false))
+}
+
+// trailing holds back results until they get their context lines after
+// the scope, line is appended where it's missing. A nil line releases all.
+func (p *Parser) trailing(line *Line, results []Result) []Result {
+	if p.opts.After == 0 {
+		return results
+	}
+	for i := range p.held {
+		r := &p.held[i]
+		if line != nil && r.Lines[len(r.Lines)-1].Num+1 == line.Num &&
+			line.Num <= r.Scope.End.Line.Num+p.opts.After {
+			r.Lines = append(r.Lines, line)
+		}
+	}
+	p.held = append(p.held, results...)
+	n := 0
+	for ; n < len(p.held); n++ {
+		r := p.held[n]
+		if line != nil && r.Lines[len(r.Lines)-1].Num < r.Scope.End.Line.Num+p.opts.After {
+			break
+		}
+	}
+	results = append([]Result(nil), p.held[:n]...)
+	p.held = p.held[n:]
+	return results
+}
+
+// markNScopes marks take scopes enclosing a match of the patterns in bits,
+// after skipping the first skip of them, returns the first marked. Scopes
+// are only counted starting from the tightest one passing the filters in
+// Options, see eligible. With Options.MatchOn matches on a header count for
+// the scope it opens, not the enclosing one. Matches span from col0 in line
+// to col1 in end, the same line unless matching multiple lines.
+func (p *Parser) markNScopes(skip, take, line, col0, end, col1 uint, bits uint64) *Scope {
+	start := p.containing(line, col0, end, col1)
+	switch p.opts.MatchOn {
+	case "header":
+		start = p.headedBy(line)
+	case "body":
+		// the opening line of a scope isn't part of its body
+		for start != nil && start.Start.Line.Num == line {
+			start = start.Parent
+		}
+	}
+	for start != nil && !p.eligible(start) {
+		start = start.Parent
+	}
+	for n := uint(0); n < skip && start != nil; n++ {
+		start = start.Parent
+	}
+	marked := start
+	for n := uint(0); n < take && start != nil && start.depth >= p.opts.MinDepth; n++ {
+		start.Match = true
+		start.Patterns |= bits
+		start = start.Parent
+	}
+	return marked
+}
+
+// marking tells how many of the scopes enclosing a match to skip, and how
+// many to mark after those
+func (p *Parser) marking() (skip, take uint) {
+	if p.opts.Ancestor > 0 {
+		return p.opts.Ancestor - 1, 1
+	}
+	return 0, p.opts.Scopes
+}
+
+// containing finds the tightest scope containing a span, nil if none
+func (p *Parser) containing(line, col0, end, col1 uint) *Scope {
+	// ASSERT p.closed is ordered from tightest to broadest
+	for _, s := range p.closed {
+		if s.Contains(line, col0, col0) && s.Contains(end, col1, col1) {
+			return s
+		}
+	}
+	// ASSERT p.open is ordered from broadest to thightest
+	for i := len(p.open) - 1; i >= 0; i-- {
+		if s := p.open[i]; s.Contains(line, col0, col0) && s.Contains(end, col1, col1) {
+			return s
+		}
+	}
+	return nil
+}
+
+// headedBy finds the scope opening last on a line, so for `if (x) {` the
+// braces and not the parens. Scopes closing on the same line have no
+// header to speak of.
+func (p *Parser) headedBy(line uint) *Scope {
+	var header *Scope
+	for _, scopes := range [][]*Scope{p.closed, p.open} {
+		for _, s := range scopes {
+			if s.End != nil && s.End.Line.Num == line {
+				continue
+			}
+			if s.Start.Line.Num == line && (header == nil || s.Start.Col > header.Start.Col) {
+				header = s
+			}
+		}
+	}
+	return header
+}
+
+func (p *Parser) addMarkers(markers Markers) bool {
+	for _, m := range markers {
+		// markers may be found on lines not buffered when read
+		p.bufferLine(m.Line)
+		// past MaxNesting delimiters are text, closers are paired with
+		// the openers dropped by count, keeping them is what's avoided
+		if m.Delim.Open && p.opts.MaxNesting > 0 && len(p.open) >= p.opts.MaxNesting {
+			p.deep++
+			p.stats.TooDeep++
+			continue
+		}
+		if !m.Delim.Open && p.deep > 0 {
+			p.deep--
+			continue
+		}
+		if m.Delim.Open {
+			newscope := p.newScope(m)
+			if len(p.open) > 0 {
+				// last open scope will be parent of this new one
+				parent := p.open[len(p.open)-1]
+				parent.Childs = append(parent.Childs, newscope)
+				newscope.Parent = parent
+			}
+			newscope.depth = len(p.open)
+			newscope.Path = m.Path
+			// only the last scope opened on a line gets its kind,
+			// so `if (x) {` is the braces and not the parens
+			if p.lang.FormKinds {
+				newscope.Kind = p.lang.kind(m.Line.Text[m.Col:])
+			} else {
+				if p.last != nil && p.last.Start.Line == m.Line {
+					p.last.Kind = ""
+				}
+				newscope.Kind = p.lang.kind(m.Line.Text)
+			}
+			p.last = newscope
+			p.open = append(p.open, newscope)
+			p.stats.Opened++
+			if uint(len(p.open)) > p.stats.MaxDepth {
+				p.stats.MaxDepth = uint(len(p.open))
+			}
+		} else {
+			// look for the opener of this closing near the top of the stack
+			opener := -1
+			for i := len(p.open) - 1; i >= 0 && i >= len(p.open)-recoverDepth; i-- {
+				if s := p.open[i].Start; s.Delim.Pair == m.Delim && m.Name == s.Name {
+					opener = i
+					break
+				}
+			}
+			if opener < 0 {
+				p.stats.Unexpected++
+				p.problem(m, "unexpected %q")
+				continue
+			}
+			// pop the scopes out of open, into closed list, tightest first
+			for len(p.open) > opener {
+				top := p.open[len(p.open)-1]
+				if len(p.open)-1 > opener {
+					p.problem(top.Start, "unclosed %q")
+				}
+				p.open = p.open[:len(p.open)-1]
+				top.End = m
+				top.Match = top.Match || p.opts.Outline && top.Parent == nil
+				// scopes within the line opening their parent, like the test
+				// of `if [ -f x ]; then`, give it back the kind
+				if top == p.last && !p.lang.FormKinds && top.Parent != nil &&
+					top.Start.Line == m.Line && top.Parent.Start.Line == m.Line {
+					top.Parent.Kind, top.Kind = top.Kind, ""
+					p.last = top.Parent
+				}
+				p.closed = append(p.closed, top)
+				p.stats.Closed++
+			}
+		}
+	}
+	return len(markers) > 0
+}
+
+// match settled lines against pattern, once their scopes are known
+func (p *Parser) matchSettled(settled uint) {
+	n := 0
+	for ; n < len(p.pending) && p.pending[n].Num < settled; n++ {
+		line := p.pending[n]
+		if p.opts.AtLine > 0 {
+			p.matchAt(line)
+			continue
+		}
+		if p.opts.Multiline {
+			continue // see matchScopes
+		}
+		if p.opts.From != nil && p.opts.Until != nil {
+			p.closeWindow(line)
+		}
+		var locs [][]int
+		for i, re := range p.patterns {
+			if p.required[i] != nil && !bytes.Contains(line.Text, p.required[i]) {
+				continue
+			}
+			found := p.inRegion(line, re.FindAllIndex(line.content(), -1))
+			if p.opts.From != nil {
+				found = p.inWindow(line, found)
+			}
+			if found == nil {
+				continue
+			}
+			loc := found[0]
+			// get n-containing scopes and mark them for printing
+			skip, take := p.marking()
+			s := p.markNScopes(skip, take, line.Num, uint(loc[0]), line.Num, uint(loc[1]), 1<<uint(i))
+			if s == nil && p.opts.Unscoped && !p.opts.Invert &&
+				p.containing(line.Num, uint(loc[0]), line.Num, uint(loc[1])) == nil {
+				s = p.lineScope(line, 1<<uint(i))
+			}
+			if s != nil {
+				s.Count += uint(len(found))
+			}
+			locs = append(locs, found...)
+			p.stats.Matches += uint(len(found))
+		}
+		if locs != nil {
+			sort.Slice(locs, func(i, j int) bool { return locs[i][0] < locs[j][0] })
+			p.matches[line.Num] = locs
+		}
+		if p.opts.From != nil {
+			p.openWindow(line)
+		}
+	}
+	p.pending = p.pending[n:]
+}
+
+// openWindow lets matches count in the scope of a line matching
+// Options.From, from the next line on
+func (p *Parser) openWindow(line *Line) {
+	loc := p.opts.From.FindAllIndex(line.content(), 1)
+	if loc == nil {
+		return
+	}
+	if s := p.containing(line.Num, uint(loc[0][0]), line.Num, uint(loc[0][1])); s != nil {
+		s.window = true
+	} else {
+		p.window = true
+	}
+}
+
+// closeWindow ends the window enclosing a line matching Options.Until,
+// matches on it don't count anymore
+func (p *Parser) closeWindow(line *Line) {
+	loc := p.opts.Until.FindAllIndex(line.content(), 1)
+	if loc == nil {
+		return
+	}
+	for s := p.containing(line.Num, uint(loc[0][0]), line.Num, uint(loc[0][1])); s != nil; s = s.Parent {
+		if s.window {
+			s.window = false
+			return
+		}
+	}
+	p.window = false
+}
+
+// inWindow keeps the matches within a window opened by Options.From in
+// their scope or one enclosing it
+func (p *Parser) inWindow(line *Line, found [][]int) [][]int {
+	var kept [][]int
+	for _, loc := range found {
+		s := p.containing(line.Num, uint(loc[0]), line.Num, uint(loc[1]))
+		for s != nil && !s.window {
+			s = s.Parent
+		}
+		if s != nil || p.window {
+			kept = append(kept, loc)
+		}
+	}
+	return kept
+}
+
+// lineDelim bounds the scopes of lines with matches out of any other, see
+// Options.Unscoped
+var lineDelim = &Delimiter{}
+
+// lineScope makes a matched scope of a line, nil if it isn't eligible
+func (p *Parser) lineScope(line *Line, bits uint64) *Scope {
+	end := uint(len(bytes.TrimRight(line.Text, "\r\n")))
+	s := p.newScope(&Marker{Delim: lineDelim, Line: line})
+	s.End = &Marker{Delim: lineDelim, Line: line, Col: end}
+	if !p.eligible(s) {
+		return nil
+	}
+	s.Match, s.Patterns = true, bits
+	p.bufferLine(line)
+	p.closed = append(p.closed, s)
+	return s
+}
+
+// matchAt marks the scopes enclosing Options.AtLine and AtCol
+func (p *Parser) matchAt(line *Line) {
+	if line.Num+1 != p.opts.AtLine {
+		return
+	}
+	col := uint(len(line.Text) - len(bytes.TrimLeft(line.Text, " \t")))
+	if p.opts.AtCol > 0 {
+		col = p.opts.AtCol - 1
+	}
+	skip, take := p.marking()
+	if s := p.markNScopes(skip, take, line.Num, col, line.Num, col, 1); s != nil {
+		s.Count++
+		p.stats.Matches++
+	}
+}
+
+// matchScopes matches the patterns across the lines of the top level
+// scopes closed, for Options.Multiline. Matches are marked on each of the
+// lines they span.
+func (p *Parser) matchScopes() {
+	for _, s := range p.closed {
+		if s.Parent != nil {
+			continue
+		}
+		var text []byte
+		var lines []*Line
+		var offsets []int // of each line in text
+		for n := s.Start.Line.Num; n <= s.End.Line.Num; n++ {
+			if line, ok := p.buffer[n]; ok {
+				lines = append(lines, line)
+				offsets = append(offsets, len(text))
+				text = append(text, line.Text...)
+			}
+		}
+		// line and column of an offset in text
+		at := func(off int) (*Line, int) {
+			i := sort.Search(len(offsets), func(i int) bool { return offsets[i] > off }) - 1
+			return lines[i], off - offsets[i]
+		}
+		for i, re := range p.patterns {
+			for _, loc := range re.FindAllIndex(text, -1) {
+				first, col0 := at(loc[0])
+				last, col1 := at(loc[0])
+				if loc[1] > loc[0] {
+					last, col1 = at(loc[1] - 1)
+					col1++
+				}
+				skip, take := p.marking()
+				tightest := p.markNScopes(skip, take, first.Num, uint(col0), last.Num, uint(col1), 1<<uint(i))
+				if tightest == nil {
+					continue
+				}
+				tightest.Count++
+				p.stats.Matches++
+				for _, line := range lines {
+					if line.Num < first.Num || line.Num > last.Num {
+						continue
+					}
+					from, to := 0, len(bytes.TrimRight(line.Text, "\r\n"))
+					if line == first {
+						from = col0
+					}
+					if line == last {
+						to = col1
+					}
+					p.matches[line.Num] = append(p.matches[line.Num], []int{from, to})
+				}
+			}
+		}
+		for _, line := range lines {
+			locs := p.matches[line.Num]
+			sort.Slice(locs, func(i, j int) bool { return locs[i][0] < locs[j][0] })
+		}
+	}
+}
+
+// inRegion filters the matches in a line to those within Options.Region
+func (p *Parser) inRegion(line *Line, found [][]int) [][]int {
+	if p.opts.Region == "" || found == nil {
+		return found
+	}
+	kind := regionKinds[p.opts.Region]
+	var kept [][]int
+	for _, loc := range found {
+		if within(line.regions, kind, loc[0], loc[1]) {
+			kept = append(kept, loc)
+		}
+	}
+	return kept
+}
+
+func (p *Parser) flushMatching(openScopes bool) []Result {
+	var results []Result
+	if p.opts.All && len(p.patterns) > 1 {
+		p.requireAll()
+	}
+	if p.opts.Invert {
+		p.invertClosed()
+	}
+	if p.opts.Query != nil {
+		p.queryClosed()
+	}
+	p.consolidateClosed()
+	for _, s := range p.closed {
+		if s.Match && p.sized(s) {
+			results = append(results, p.result(s))
+		}
+	}
+	p.closed = p.closed[0:0]
+	if openScopes {
+		for _, s := range p.open {
+			if s.Match && p.sized(s) {
+				r := p.result(s)
+				if p.opts.Query == nil || p.opts.Query.Match(r) {
+					results = append(results, r)
+				}
+			}
+		}
+	}
+	return results
+}
+
+// queryClosed unmarks the closed scopes Options.Query doesn't hold for.
+// Without patterns any scope may match, the query alone picks them.
+func (p *Parser) queryClosed() {
+	for _, s := range p.closed {
+		if len(p.patterns) == 0 && !p.opts.Invert {
+			s.Match = p.eligible(s)
+		}
+		s.Match = s.Match && p.opts.Query.Match(p.result(s))
+	}
+}
+
+// sized checks the lines a scope spans against Options.MinSpan and
+// MaxSpan, those read so far if it's open
+func (p *Parser) sized(s *Scope) bool {
+	end := p.lineno - 1
+	if s.End != nil {
+		end = s.End.Line.Num
+	}
+	n := int(end - s.Start.Line.Num + 1)
+	return n >= p.opts.MinSpan && (p.opts.MaxSpan <= 0 || n <= p.opts.MaxSpan)
+}
+
+// result collects the buffered lines of a scope
+func (p *Parser) result(s *Scope) Result {
+	r := Result{Scope: s, Matches: make(map[uint][][]int)}
+	start, end, last := s.Start.Line.Num, p.lineno-1, p.lineno-1
+	if s.End != nil {
+		end = s.End.Line.Num
+		// lines after the scope may already be read, see trailing
+		if end+p.opts.After < last {
+			last = end + p.opts.After
+		}
+	}
+	for l := p.contextStart(start); l <= last; l++ {
+		if line, ok := p.buffer[l]; ok {
+			r.Lines = append(r.Lines, line)
+		}
+		if locs, ok := p.matches[l]; ok && l >= start && l <= end {
+			r.Matches[l] = locs
+		}
+	}
+	return r
+}
+
+// contextStart is the first line of context before line still buffered
+func (p *Parser) contextStart(line uint) uint {
+	if line < p.low+p.opts.Before {
+		return p.low
+	}
+	return line - p.opts.Before
+}
+
+func (p *Parser) bufferLine(line *Line) {
+	if _, ok := p.buffer[line.Num]; !ok {
+		p.buffer[line.Num] = line
+		p.size += len(line.Text)
+		if p.size > p.stats.Buffered {
+			p.stats.Buffered = p.size
+		}
+	}
+}
+
+func (p *Parser) unbuffer(num uint) {
+	if line, ok := p.buffer[num]; ok {
+		delete(p.buffer, num)
+		p.size -= len(line.Text)
+	}
+	delete(p.matches, num)
+}
+
+// prune drops the lines before any scope that can still be reported
+func (p *Parser) prune() {
+	low := p.lineno
+	if len(p.pending) > 0 {
+		low = p.pending[0].Num
+	}
+	for _, scopes := range [][]*Scope{p.closed, p.open} {
+		for _, s := range scopes {
+			if s.Start.Line.Num < low {
+				low = s.Start.Line.Num
+			}
+		}
+	}
+	// keep a window of lines that may become context of a later scope
+	low = p.contextStart(low)
+	for ; p.low < low; p.low++ {
+		p.unbuffer(p.low)
+	}
+}
+
+// elide drops settled lines without matches from the buffer, keeping
+// the opening lines of scopes still open
+func (p *Parser) elide() {
+	keep := make(map[uint]bool)
+	for _, s := range p.open {
+		keep[s.Start.Line.Num] = true
+	}
+	settled := p.lineno
+	if len(p.pending) > 0 {
+		settled = p.pending[0].Num
+	}
+	if p.elided < p.low {
+		p.elided = p.low
+	}
+	for ; p.elided < settled; p.elided++ {
+		if _, ok := p.matches[p.elided]; !ok && !keep[p.elided] {
+			p.unbuffer(p.elided)
+		}
+	}
+}
+
+// requireAll only keeps the tightest scopes matching every pattern, along
+// with their N-1 parents
+func (p *Parser) requireAll() {
+	all := uint64(1)<<uint(len(p.patterns)) - 1
+	full := make(map[*Scope]bool)
+	for _, s := range p.closed {
+		full[s] = s.Union() == all && p.eligible(s)
+		s.Match = false
+	}
+	// closed is ordered tightest first, so children are decided before parents
+	for _, s := range p.closed {
+		if !full[s] {
+			continue
+		}
+		tightest := true
+		for _, c := range s.Childs {
+			tightest = tightest && !full[c]
+		}
+		if !tightest {
+			continue
+		}
+		skip, take := p.marking()
+		t := s
+		for n := uint(0); n < skip && t != nil; n++ {
+			t = t.Parent
+		}
+		for n := uint(0); n < take && t != nil && t.depth >= p.opts.MinDepth; n++ {
+			t.Match = true
+			t = t.Parent
+		}
+	}
+}
+
+// invertClosed flips closed scopes to match only if they have no matches,
+// neither directly nor in any child scope
+func (p *Parser) invertClosed() {
+	for _, s := range p.closed {
+		s.Match = s.Total() == 0 && p.eligible(s)
+	}
+}
+
+// eligible checks a scope against the depth limits, kind and Options.Head
+func (p *Parser) eligible(s *Scope) bool {
+	if s.depth < p.opts.MinDepth || p.opts.DepthLimit > 0 && s.depth >= p.opts.DepthLimit {
+		return false
+	}
+	if p.opts.Kind != "" && s.Kind != p.opts.Kind {
+		return false
+	}
+	return p.opts.Head == nil || p.opts.Head.FindAllIndex(s.Start.Line.content(), 1) != nil
+}
+
+// discard closed scopes which didn't match
+// if a scope and it's parent have a match, only keep parent
+// unless Options.Keep asks for the tightest or all of them
+func (p *Parser) consolidateClosed() {
+	closed := make([]*Scope, 0, len(p.closed))
+	moved := make(map[*Scope]struct{})
+	for _, scope := range p.closed {
+		if !scope.Match {
+			continue
+		}
+		switch p.opts.Keep {
+		case "all":
+			closed = append(closed, scope)
+		case "tightest":
+			// scopes with matches of their own, or none in their childs
+			if scope.Count > 0 || !childMatch(scope) {
+				closed = append(closed, scope)
+			}
+		default:
+			// search for largest-containing-matching scope
+			for scope.Parent != nil && scope.Parent.Match {
+				scope = scope.Parent
+			}
+			// only insert once and if closed scope
+			if _, ok := moved[scope]; !ok && scope.End != nil {
+				closed = append(closed, scope)
+				moved[scope] = struct{}{}
+			}
+		}
+	}
+	p.closed = closed
+}
+
+func childMatch(s *Scope) bool {
+	for _, c := range s.Childs {
+		if c.Match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,375 @@
+package sgrep
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// goSource makes n lines of Go-like code with nested blocks, comments and
+// string literals, for benchmarks
+func goSource(n int) []string {
+	lines := make([]string, 0, n)
+	for i := 0; len(lines) < n; i++ {
+		lines = append(lines,
+			fmt.Sprintf("// handle%d deals with the {braces} in comments too\n", i),
+			fmt.Sprintf("func handle%d(w io.Writer, args []string) error {\n", i),
+			"\tfor _, arg := range args {\n",
+			"\t\tif strings.HasPrefix(arg, \"{\") && len(arg) > 1 {\n",
+			"\t\t\tfmt.Fprintf(w, \"%s: (%d)\\n\", arg[1:], len(arg))\n",
+			"\t\t}\n",
+			"\t}\n",
+			"\treturn nil\n",
+			"}\n",
+			"\n")
+	}
+	return lines[:n]
+}
+
+// prose makes n lines of log-like text without delimiters, for benchmarks
+func prose(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = strings.Repeat("request served to client after waiting ", 2) + fmt.Sprint(i) + "\n"
+	}
+	return lines
+}
+
+// feed parses lines with a new parser, returning the results
+func feed(tb testing.TB, opts Options, lines []string, patterns ...Matcher) []Result {
+	p, err := NewParser(opts, patterns...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var results []Result
+	for _, l := range lines {
+		results = append(results, p.Feed([]byte(l))...)
+	}
+	return append(results, p.Close()...)
+}
+
+func BenchmarkParse(b *testing.B) {
+	lines := goSource(10000)
+	re := regexp.MustCompile(`Fprintf`)
+	lang, _ := LookupLanguage("go")
+	opts := Options{Language: lang}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		feed(b, opts, lines, re)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	// lines much longer than bufio's buffer, with delimiters past it
+	long := strings.Repeat("x", 70000)
+	input := "f() {\n" + long + " needle " + long + "\n" + long + " }\n" + "after\n"
+	results, err := Search(strings.NewReader(input), regexp.MustCompile("needle"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	r := results[0]
+	if start, end := r.Scope.Start, r.Scope.End; start.Line.Num != 0 || end == nil || end.Line.Num != 2 || end.Col != uint(len(long)+1) {
+		t.Errorf("scope %v, want 0:4 - 2:%d", r.Scope, len(long)+1)
+	}
+	lines := strings.SplitAfter(input, "\n")
+	if len(r.Lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(r.Lines))
+	}
+	for i, l := range r.Lines {
+		if string(l.Text) != lines[i] {
+			t.Errorf("line %d has %d bytes, want %d", i, len(l.Text), len(lines[i]))
+		}
+	}
+	if locs := r.Matches[1]; len(locs) != 1 || locs[0][0] != len(long)+1 {
+		t.Errorf("matches %v, want one at %d", locs, len(long)+1)
+	}
+}
+
+// starts lists where the scopes of results open, as line:col 0-based
+func starts(results []Result) []string {
+	var s []string
+	for _, r := range results {
+		s = append(s, fmt.Sprintf("%d:%d", r.Scope.Start.Line.Num, r.Scope.Start.Col))
+	}
+	return s
+}
+
+func TestKeep(t *testing.T) {
+	lines := []string{
+		"a {\n",
+		"  b {\n",
+		"    needle\n",
+		"  }\n",
+		"  c { needle }\n",
+		"}\n",
+		"d { needle }\n",
+	}
+	tests := []struct {
+		keep   string
+		scopes uint
+		want   []string
+	}{
+		{"", 1, []string{"1:4", "4:4", "6:2"}},
+		{"broadest", 1, []string{"1:4", "4:4", "6:2"}},
+		{"broadest", 2, []string{"0:2", "6:2"}},
+		{"tightest", 2, []string{"1:4", "4:4", "6:2"}},
+		{"all", 2, []string{"1:4", "4:4", "0:2", "6:2"}},
+		{"all", 1, []string{"1:4", "4:4", "6:2"}},
+	}
+	for _, tc := range tests {
+		opts := Options{Keep: tc.keep, Scopes: tc.scopes}
+		got := starts(feed(t, opts, lines, regexp.MustCompile("needle")))
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("keep %q with %d scopes: got %v, want %v", tc.keep, tc.scopes, got, tc.want)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	lang, _ := LookupLanguage("go")
+	p, err := NewParser(Options{Language: lang}, regexp.MustCompile("needle"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := func(lines []string) []Result {
+		var results []Result
+		for _, l := range lines {
+			results = append(results, p.Feed([]byte(l))...)
+		}
+		return append(results, p.Close()...)
+	}
+	first := search([]string{"a {\n", "  needle\n", "}\n"})
+	want := starts(first)
+	if err := p.Reset(lang); err != nil {
+		t.Fatal(err)
+	}
+	search([]string{"\n", "b { c {\n", "  needle } }\n"})
+	if got := starts(first); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("results of the first input changed after Reset: got %v, want %v", got, want)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	lines := []string{"a {\n", "  needle\n", "}}\n"}
+	// nobody reads an unbuffered channel, parsing must not wait on it
+	feed(t, Options{Warnings: make(chan *Error)}, lines, regexp.MustCompile("needle"))
+
+	warnings := make(chan *Error, 1)
+	feed(t, Options{Warnings: warnings}, lines, regexp.MustCompile("needle"))
+	select {
+	case w := <-warnings:
+		if w.Kind != UnbalancedError || w.Line != 3 || w.Col != 2 {
+			t.Errorf("got warning %v, want an unbalanced } at 3:2", w)
+		}
+	default:
+		t.Error("no warning for the unpaired }")
+	}
+}
+
+func TestUnscoped(t *testing.T) {
+	lines := []string{
+		"needle at the top\r\n",
+		"a {\n",
+		"  needle\n",
+		"}\n",
+		"needle again\n",
+	}
+	tests := []struct {
+		unscoped bool
+		want     []string
+	}{
+		{false, []string{"1:2"}},
+		{true, []string{"0:0", "1:2", "4:0"}},
+	}
+	for _, tc := range tests {
+		results := feed(t, Options{Unscoped: tc.unscoped}, lines, regexp.MustCompile("needle"))
+		if got := starts(results); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("unscoped %v: got %v, want %v", tc.unscoped, got, tc.want)
+		}
+		for _, r := range results {
+			if s := r.Scope; s.Start.Delim == lineDelim && (s.End.Line != s.Start.Line ||
+				s.End.Col != uint(len(bytes.TrimRight(s.Start.Line.Text, "\r\n")))) {
+				t.Errorf("line %d: scope should end with its line, at %d", s.Start.Line.Num, s.End.Col)
+			}
+		}
+	}
+}
+
+// tree renders a scope and those within as start-end {childs}, 0-based
+// line:col
+func tree(s *Scope) string {
+	b := fmt.Sprintf("%d:%d-", s.Start.Line.Num, s.Start.Col)
+	if s.End != nil {
+		b += fmt.Sprintf("%d:%d", s.End.Line.Num, s.End.Col)
+	} else {
+		b += "*"
+	}
+	if len(s.Childs) > 0 {
+		var childs []string
+		for _, c := range s.Childs {
+			childs = append(childs, tree(c))
+		}
+		b += " {" + strings.Join(childs, " ") + "}"
+	}
+	return b
+}
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{"nested", []string{"a {\n", "  b(c[1]) {\n", "  }\n", "}\n", "d { }\n"},
+			[]string{"0:2-3:0 {1:3-1:8 {1:5-1:7} 1:10-2:2}", "4:2-4:4"}},
+		{"unclosed opener", []string{"a {\n", "  b (\n", "}\n"},
+			[]string{"0:2-2:0 {1:4-2:0}"}},
+		{"stray closer", []string{"}\n", "a { ) }\n"},
+			[]string{"1:2-1:6"}},
+		// scopes still open at the end aren't reported
+		{"open at the end", []string{"a {\n", "  b { }\n"}, nil},
+	}
+	lang, _ := LookupLanguage("go")
+	for _, tc := range tests {
+		var got []string
+		for _, r := range feed(t, Options{Language: lang, Outline: true}, tc.lines) {
+			got = append(got, tree(r.Scope))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMarkNScopes(t *testing.T) {
+	tests := []struct {
+		skip, take uint
+		minDepth   int
+		want       string // marked scopes, by the line they open at
+		returned   int    // line of the scope returned, -1 for nil
+	}{
+		{0, 1, 0, "2", 2},
+		{0, 2, 0, "1 2", 2},
+		{0, 5, 0, "0 1 2", 2},
+		{1, 1, 0, "1", 1},
+		{2, 5, 0, "0", 0},
+		{3, 1, 0, "", -1},
+		{0, 5, 1, "1 2", 2},
+	}
+	lang, _ := LookupLanguage("go")
+	for _, tc := range tests {
+		p, err := NewParser(Options{Language: lang, MinDepth: tc.minDepth})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range []string{"a {\n", "  b {\n", "    c {\n", "      needle\n"} {
+			p.Feed([]byte(l))
+		}
+		s := p.markNScopes(tc.skip, tc.take, 3, 6, 3, 12, 1)
+		var marked []string
+		for _, o := range p.open {
+			if o.Match {
+				marked = append(marked, fmt.Sprint(o.Start.Line.Num))
+			}
+		}
+		returned := -1
+		if s != nil {
+			returned = int(s.Start.Line.Num)
+		}
+		if strings.Join(marked, " ") != tc.want || returned != tc.returned {
+			t.Errorf("skip %d take %d min depth %d: marked %v returning %d, want %q returning %d",
+				tc.skip, tc.take, tc.minDepth, marked, returned, tc.want, tc.returned)
+		}
+	}
+}
+
+func TestConsolidateClosed(t *testing.T) {
+	// a { b { c { } } d { } }, b, c and d matched, c and d with matches of
+	// their own and b only through c
+	at := func(n uint) *Marker { return &Marker{Line: &Line{Num: n}} }
+	a := &Scope{Start: at(0), End: at(9)}
+	b := &Scope{Start: at(1), End: at(4), Parent: a, Match: true}
+	c := &Scope{Start: at(2), End: at(3), Parent: b, Match: true, Count: 1}
+	d := &Scope{Start: at(5), End: at(6), Parent: a, Match: true, Count: 2}
+	a.Childs, b.Childs = []*Scope{b, d}, []*Scope{c}
+	names := map[*Scope]string{a: "a", b: "b", c: "c", d: "d"}
+	tests := []struct {
+		keep string
+		want string
+	}{
+		{"", "b d"},
+		{"broadest", "b d"},
+		{"tightest", "c d"},
+		{"all", "c b d"},
+	}
+	for _, tc := range tests {
+		p := &Parser{opts: Options{Keep: tc.keep}, closed: []*Scope{c, b, d}}
+		p.consolidateClosed()
+		var got []string
+		for _, s := range p.closed {
+			got = append(got, names[s])
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("keep %q: got %v, want %q", tc.keep, got, tc.want)
+		}
+	}
+	// the broadest match may still be open, then nothing is reported yet
+	a.Match, a.End = true, nil
+	p := &Parser{closed: []*Scope{c, b, d}}
+	if p.consolidateClosed(); len(p.closed) != 0 {
+		t.Errorf("got %d scopes within an open match, want none", len(p.closed))
+	}
+}
+
+// FuzzParser feeds arbitrary input through a parser for any language, or
+// delimiters of its own, checking nothing panics and scopes make sense
+func FuzzParser(f *testing.F) {
+	f.Add([]byte("a {\n  b(c[1]) {\n}\n}}\n"), uint8(0), "", "", uint8(0))
+	f.Add([]byte("begin\n  if x then\nend end\n"), uint8(1), "begin", "end", uint8(1))
+	f.Add([]byte("<a>\n<b/>\n</c>\n</a>\n"), uint8(2), "<", ">", uint8(2))
+	f.Add([]byte("x = \"{\" /* { */ // }\n}\n"), uint8(3), "{", "}", uint8(3))
+	f.Fuzz(func(t *testing.T, data []byte, lang uint8, open, close string, flags uint8) {
+		opts := Options{
+			Language:   Languages[int(lang)%len(Languages)],
+			Keep:       []string{"broadest", "tightest", "all"}[flags%3],
+			Scopes:     uint(flags>>2)%3 + 1,
+			NoLiterals: flags&0x10 != 0,
+			Unscoped:   flags&0x20 != 0,
+			MaxLines:   int(flags >> 6),
+		}
+		if open != "" && close != "" && open != close {
+			opts.Delims = [][2]string{{open, close}}
+		}
+		p, err := NewParser(opts, regexp.MustCompile(`[a-z]`))
+		if err != nil {
+			return
+		}
+		var results []Result
+		for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+			if len(line) > 0 {
+				results = append(results, p.Feed(line)...)
+			}
+		}
+		results = append(results, p.Close()...)
+		for _, r := range results {
+			s := r.Scope
+			if s.End == nil {
+				continue
+			}
+			if start, end := s.Start, s.End; end.Line.Num < start.Line.Num ||
+				end.Line.Num == start.Line.Num && end.Col < start.Col {
+				t.Fatalf("scope %v ends before it starts", s)
+			}
+			for _, c := range s.Childs {
+				if c.Parent != s {
+					t.Fatalf("scope %v within %v has parent %v", c, s, c.Parent)
+				}
+			}
+		}
+	})
+}
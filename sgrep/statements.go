@@ -0,0 +1,103 @@
+package sgrep
+
+import "strings"
+
+// states of a nesting level for statements
+const (
+	stmtNone      = iota // between statements
+	stmtOpen             // a statement is open
+	stmtUntracked        // in parens, CASE and the like
+)
+
+// statements makes scopes of the statements ended by a terminator, sql
+// style. They're found at the top level and in blocks like BEGIN ... END,
+// not within parens or other scopes.
+type statements struct {
+	open, close *Delimiter
+	blocks      map[string]bool // openers holding statements, lowercase
+	levels      []int           // state of each nesting level
+	last        *Line           // last line with code, statements open at the end close there
+	lastCol     uint
+}
+
+func newStatements(terminator string, blocks []string) *statements {
+	s := &statements{
+		open:   &Delimiter{Open: true},
+		close:  &Delimiter{Str: terminator},
+		blocks: make(map[string]bool),
+		levels: []int{stmtNone},
+	}
+	s.open.Pair, s.close.Pair = s.close, s.open
+	for _, b := range blocks {
+		s.blocks[strings.ToLower(b)] = true
+	}
+	return s
+}
+
+// scan adds the statements to the markers of a line, terminators only
+// count at levels tracking statements
+func (s *statements) scan(l *Line, markers Markers) Markers {
+	var out Markers
+	next := 0
+	for col := 0; col < len(l.Text); col++ {
+		first := next
+		for next < len(markers) && int(markers[next].Col) == col {
+			next++
+		}
+		at := markers[first:next]
+		c := l.Text[col]
+		code := c != ' ' && c != '\t' && c != '\r' && c != '\n' && inCode(l.regions, col)
+		if code {
+			s.last, s.lastCol = l, uint(col+1)
+		}
+		// statements start at their first token, unless it ends them
+		if code && s.top() == stmtNone && (len(at) == 0 || at[0].Delim.Open) {
+			out = append(out, &Marker{Delim: s.open, Line: l, Col: uint(col)})
+			s.levels[len(s.levels)-1] = stmtOpen
+		}
+		for _, m := range at {
+			switch {
+			case m.Delim == s.close:
+				if s.top() == stmtOpen {
+					out = append(out, m)
+					s.levels[len(s.levels)-1] = stmtNone
+				}
+				continue
+			case m.Delim.Open:
+				level := stmtUntracked
+				if s.blocks[strings.ToLower(m.Delim.Str)] {
+					level = stmtNone
+				}
+				s.levels = append(s.levels, level)
+			default:
+				if s.top() == stmtOpen {
+					out = append(out, &Marker{Delim: s.close, Line: l, Col: m.Col})
+				}
+				if len(s.levels) > 1 {
+					s.levels = s.levels[:len(s.levels)-1]
+				}
+			}
+			out = append(out, m)
+		}
+		// the rest of a keyword doesn't start statements
+		for _, m := range at {
+			if end := col + len(m.Delim.Str) - 1; end > col {
+				col = end
+			}
+		}
+	}
+	return out
+}
+
+func (s *statements) top() int { return s.levels[len(s.levels)-1] }
+
+// finish closes the statements left without a terminator
+func (s *statements) finish() Markers {
+	var markers Markers
+	for i := len(s.levels) - 1; i >= 0; i-- {
+		if s.levels[i] == stmtOpen {
+			markers = append(markers, &Marker{Delim: s.close, Line: s.last, Col: s.lastCol})
+		}
+	}
+	return markers
+}
@@ -0,0 +1,28 @@
+#include <stdio.h>
+
+/* count lines, a { in a comment doesn't open anything */
+static int count(FILE *f)
+{
+	int c, n = 0;
+	while ((c = fgetc(f)) != EOF) {
+		if (c == '\n')
+			n++;
+	}
+	return n;
+}
+
+#ifdef DEBUG
+int debug = 1;
+#endif
+
+int main(int argc, char **argv)
+{
+	const char *s = "}";
+	if (argc < 2) {
+		fprintf(stderr, "usage: %s FILE\n", argv[0]);
+		return 2;
+	}
+	FILE *f = fopen(argv[1], "r");
+	printf("%d %s\n", count(f), s);
+	return 0;
+}
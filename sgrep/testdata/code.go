@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// config holds the {settings} read from the command line
+type config struct {
+	name    string
+	retries int
+}
+
+func parse(args []string) (*config, error) {
+	c := &config{retries: 3}
+	for i, arg := range args {
+		switch {
+		case arg == "-n" && i+1 < len(args):
+			c.name = args[i+1]
+		case arg == "{":
+			return nil, fmt.Errorf("unexpected %q", arg)
+		}
+	}
+	return c, nil
+}
+
+func main() {
+	c, err := parse(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fmt.Println(c.name)
+}
@@ -0,0 +1,353 @@
+package sgrep
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// regionKind tells what a span of a line is made of
+type regionKind int
+
+const (
+	codeRegion regionKind = iota
+	stringRegion
+	commentRegion
+)
+
+// region is a [start, end) span of a line
+type region struct {
+	start, end int
+	kind       regionKind
+	open       string // block comment token opening the region on this line
+	close      string // block comment token closing the region on this line
+}
+
+// tokenizer splits lines into code, literal and comment regions so that
+// delimiters inside them aren't taken as scope markers. State is carried
+// from one line to the next for block comments, multi-line literals,
+// heredocs and for literals continued with a backslash.
+type tokenizer struct {
+	quotes   string      // characters opening a string literal
+	comments []string    // line comment prefixes
+	blocks   [][2]string // block comment open/close tokens
+	literals [][2]string // raw multi-line literal open/close tokens
+	heredocs bool        // <<WORD starts a literal on the next line
+	chars    []string    // prefixes of single character literals
+	// preprocessor directives and the conditional branches left out
+	preprocessor bool
+	directive    bool        // the last directive continues on the next line
+	conds        []condition // #if being read, innermost last
+	quote        byte        // quote of the literal currently open, 0 in code
+	block        string      // closing token of the open block comment
+	literal      string      // closing token of the open multi-line literal
+	heredoc      []string    // words ending the heredocs started, in order
+	starts       *[256]bool  // bytes tokens start with, see skip
+}
+
+func newTokenizer(comments []string, blocks [][2]string) *tokenizer {
+	return &tokenizer{comments: comments, blocks: blocks}
+}
+
+// heredocStart matches <<WORD, <<-WORD, <<~WORD and quoted words
+var heredocStart = regexp.MustCompile(`^<<[-~]?[ \t]*(?:'(\w+)'|"(\w+)"|(\w+))`)
+
+// inLiteral tells if the next line starts within a multi-line literal
+func (t *tokenizer) inLiteral() bool {
+	return t.literal != "" || len(t.heredoc) > 0
+}
+
+func (t *tokenizer) regions(line []byte) []region {
+	if t.preprocessor && t.block == "" && t.literal == "" && t.quote == 0 && t.preprocess(line) {
+		return []region{{start: 0, end: len(line), kind: commentRegion}}
+	}
+	// heredoc bodies are literal up to and including the closing word
+	if len(t.heredoc) > 0 {
+		if string(bytes.TrimSpace(line)) == t.heredoc[0] {
+			t.heredoc = t.heredoc[1:]
+		}
+		return []region{{start: 0, end: len(line), kind: stringRegion}}
+	}
+	regions := make([]region, 0, 2)
+	cur := region{kind: codeRegion}
+	if t.quote != 0 || t.literal != "" {
+		cur.kind = stringRegion
+	} else if t.block != "" {
+		cur.kind = commentRegion
+	}
+	// close the current region at end and start a new one there
+	next := func(end int, kind regionKind) {
+		cur.end = end
+		if cur.start < cur.end {
+			regions = append(regions, cur)
+		}
+		cur = region{start: end, kind: kind}
+	}
+	for i := 0; i < len(line); i++ {
+		if i = t.skip(line, i); i == len(line) {
+			break
+		}
+		c := line[i]
+		switch {
+		case t.block != "":
+			if bytes.HasPrefix(line[i:], []byte(t.block)) {
+				i += len(t.block) - 1
+				cur.close, t.block = t.block, ""
+				next(i+1, codeRegion)
+			}
+		case t.literal != "":
+			// raw literals have no escapes
+			if bytes.HasPrefix(line[i:], []byte(t.literal)) {
+				i += len(t.literal) - 1
+				t.literal = ""
+				next(i+1, codeRegion)
+			}
+		case t.quote != 0:
+			switch c {
+			case '\\':
+				i++ // skip escaped character
+			case t.quote:
+				t.quote = 0
+				next(i+1, codeRegion)
+			}
+		default:
+			if n := charAt(line, i, t.chars); n > 0 {
+				// #\( or #\" in lisp are a character, not code
+				next(i, stringRegion)
+				i += n - 1
+				next(i+1, codeRegion)
+			} else if open, close := t.blockAt(line, i); open != "" {
+				next(i, commentRegion)
+				cur.open, t.block = open, close
+				i += len(open) - 1
+			} else if hasPrefixAt(line, i, t.comments) {
+				next(i, commentRegion)
+				i = len(line)
+			} else if open, close := t.literalAt(line, i); open != "" {
+				next(i, stringRegion)
+				t.literal = close
+				i += len(open) - 1
+			} else if m := t.heredocAt(line, i); m != nil {
+				// the word is code, the literal starts on the next line
+				for _, word := range m[1:] {
+					if word != nil {
+						t.heredoc = append(t.heredoc, string(word))
+					}
+				}
+				i += len(m[0]) - 1
+			} else if isQuote(t.quotes, c) {
+				next(i, stringRegion)
+				t.quote = c
+			}
+		}
+	}
+	next(len(line), codeRegion)
+	// literals only go on to the next line if the newline is escaped
+	if t.quote != 0 && !continued(line) {
+		t.quote = 0
+	}
+	return regions
+}
+
+// skip returns the first position from i where the state may change: the
+// closing token of a block comment or literal, a quote or backslash in a
+// string, a byte some token starts with in code. Positions in between are
+// looked at once instead of trying every token on them.
+func (t *tokenizer) skip(line []byte, i int) int {
+	var j int
+	switch {
+	case t.block != "":
+		j = bytes.Index(line[i:], []byte(t.block))
+	case t.literal != "":
+		j = bytes.Index(line[i:], []byte(t.literal))
+	case t.quote != 0:
+		for ; i < len(line) && line[i] != '\\' && line[i] != t.quote; i++ {
+		}
+		return i
+	default:
+		if t.starts == nil {
+			t.starts = t.startBytes()
+		}
+		for ; i < len(line) && !t.starts[line[i]]; i++ {
+		}
+		return i
+	}
+	if j < 0 {
+		return len(line)
+	}
+	return i + j
+}
+
+// startBytes are the first bytes of quotes and the tokens starting comments
+// and literals
+func (t *tokenizer) startBytes() *[256]bool {
+	starts := new([256]bool)
+	for _, list := range [][]string{t.comments, t.chars} {
+		for _, tok := range list {
+			starts[tok[0]] = true
+		}
+	}
+	for _, pairs := range [][][2]string{t.blocks, t.literals} {
+		for _, p := range pairs {
+			starts[p[0][0]] = true
+		}
+	}
+	for i := 0; i < len(t.quotes); i++ {
+		starts[t.quotes[i]] = true
+	}
+	starts['<'] = starts['<'] || t.heredocs
+	return starts
+}
+
+// condition is an #if, #ifdef or #ifndef and the branch being read
+type condition struct {
+	outer bool // the #if itself is in code
+	live  bool // the branch being read is code
+	taken bool // some branch was code already
+}
+
+var directive = regexp.MustCompile(`^[ \t]*#[ \t]*(\w*)[ \t]*(\w*)`)
+
+// preprocess checks if a line isn't code for the preprocessor: directives,
+// their continuation lines and the branches of conditionals left out. The
+// first branch of a conditional is taken to be code, but for #if 0.
+func (t *tokenizer) preprocess(line []byte) bool {
+	live := len(t.conds) == 0 || t.conds[len(t.conds)-1].live
+	if t.directive {
+		t.directive = continued(line)
+		return true
+	}
+	m := directive.FindSubmatch(line)
+	if m == nil {
+		return !live
+	}
+	t.directive = continued(line)
+	switch string(m[1]) {
+	case "if", "ifdef", "ifndef":
+		first := live && !(string(m[1]) == "if" && string(m[2]) == "0")
+		t.conds = append(t.conds, condition{outer: live, live: first, taken: first})
+	case "elif", "else":
+		if len(t.conds) > 0 {
+			c := &t.conds[len(t.conds)-1]
+			c.live = c.outer && !c.taken
+			c.taken = c.taken || c.live
+		}
+	case "endif":
+		if len(t.conds) > 0 {
+			t.conds = t.conds[:len(t.conds)-1]
+		}
+	}
+	return true
+}
+
+// blockAt returns the tokens of a block comment starting at i
+func (t *tokenizer) blockAt(line []byte, i int) (string, string) {
+	for _, b := range t.blocks {
+		if bytes.HasPrefix(line[i:], []byte(b[0])) {
+			return b[0], b[1]
+		}
+	}
+	return "", ""
+}
+
+// literalAt returns the tokens of a multi-line literal starting at i,
+// openers like r" need to start a word
+func (t *tokenizer) literalAt(line []byte, i int) (string, string) {
+	for _, l := range t.literals {
+		if bytes.HasPrefix(line[i:], []byte(l[0])) && (i == 0 || !isWordByte(l[0][0]) || !isWordByte(line[i-1])) {
+			return l[0], l[1]
+		}
+	}
+	return "", ""
+}
+
+// heredocAt matches a heredoc start at i, <<< is a here-string instead
+func (t *tokenizer) heredocAt(line []byte, i int) [][]byte {
+	if !t.heredocs || line[i] != '<' || i > 0 && line[i-1] == '<' {
+		return nil
+	}
+	return heredocStart.FindSubmatch(line[i:])
+}
+
+// charAt returns the length of a character literal at i, the prefix and the
+// character following it
+func charAt(line []byte, i int, prefixes []string) int {
+	for _, p := range prefixes {
+		if n := len(p); bytes.HasPrefix(line[i:], []byte(p)) && i+n < len(line) && line[i+n] != '\n' {
+			return n + 1
+		}
+	}
+	return 0
+}
+
+func isQuote(quotes string, c byte) bool {
+	for i := 0; i < len(quotes); i++ {
+		if quotes[i] == c {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefixAt(line []byte, i int, prefixes []string) bool {
+	for _, p := range prefixes {
+		if bytes.HasPrefix(line[i:], []byte(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// continued checks for a backslash right before the end of line
+func continued(line []byte) bool {
+	n := len(line)
+	for n > 0 && (line[n-1] == '\n' || line[n-1] == '\r') {
+		n--
+	}
+	return n > 0 && line[n-1] == '\\'
+}
+
+// Regions a match can be restricted to, see Options.Region
+var Regions = []string{"code", "comments", "strings"}
+
+var regionKinds = map[string]regionKind{
+	"code":     codeRegion,
+	"comments": commentRegion,
+	"strings":  stringRegion,
+}
+
+// within checks if the span [start, end) only covers regions of a kind,
+// lines without regions are all code
+func within(regions []region, kind regionKind, start, end int) bool {
+	if end == start {
+		end++
+	}
+	for _, r := range regions {
+		if r.start < end && start < r.end && r.kind != kind {
+			return false
+		}
+	}
+	return len(regions) > 0 || kind == codeRegion
+}
+
+// inCode checks if the column falls in a code region
+func inCode(regions []region, col int) bool {
+	for _, r := range regions {
+		if col >= r.start && col < r.end {
+			return r.kind == codeRegion
+		}
+	}
+	return true
+}
+
+// atCommentEdge checks if delim at col is the token opening or closing a
+// block comment
+func atCommentEdge(regions []region, col int, delim string) bool {
+	for _, r := range regions {
+		if r.kind == commentRegion &&
+			((r.open == delim && r.start == col) ||
+				(r.close == delim && r.end == col+len(delim))) {
+			return true
+		}
+	}
+	return false
+}
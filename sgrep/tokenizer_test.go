@@ -0,0 +1,44 @@
+package sgrep
+
+import (
+	"fmt"
+	"testing"
+)
+
+// comments makes n lines mostly of block and line comments, for benchmarks
+func comments(n int) []string {
+	lines := make([]string, 0, n)
+	for i := 0; len(lines) < n; i++ {
+		lines = append(lines,
+			"/*\n",
+			fmt.Sprintf(" * handle%d deals with the {braces} and \"quotes\" of its\n", i),
+			" * arguments, writing what it finds to w. It returns the first\n",
+			" * error found, nil otherwise.\n",
+			" */\n",
+			fmt.Sprintf("// Deprecated: use handle%d instead, which also takes a context\n", i+1),
+			"// and stops when it is done.\n",
+			fmt.Sprintf("var handler%d = handle%d // kept for the old callers\n", i, i))
+	}
+	return lines[:n]
+}
+
+func BenchmarkRegions(b *testing.B) {
+	lang, _ := LookupLanguage("go")
+	for _, input := range []struct {
+		name  string
+		lines []string
+	}{{"code", goSource(1000)}, {"comments", comments(1000)}} {
+		var lines [][]byte
+		for _, text := range input.lines {
+			lines = append(lines, []byte(text))
+		}
+		b.Run(input.name, func(b *testing.B) {
+			tokens := lang.tokenizer(&Options{})
+			for i := 0; i < b.N; i++ {
+				for _, l := range lines {
+					tokens.regions(l)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+//go:build treesitter
+
+package sgrep
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/c"
+	"github.com/smacker/go-tree-sitter/cpp"
+	"github.com/smacker/go-tree-sitter/golang"
+	"github.com/smacker/go-tree-sitter/java"
+	"github.com/smacker/go-tree-sitter/javascript"
+	"github.com/smacker/go-tree-sitter/python"
+	"github.com/smacker/go-tree-sitter/ruby"
+	"github.com/smacker/go-tree-sitter/rust"
+)
+
+// grammars for the builtin languages, by name
+var grammars = map[string]func() *sitter.Language{
+	"c":      c.GetLanguage,
+	"cpp":    cpp.GetLanguage,
+	"go":     golang.GetLanguage,
+	"java":   java.GetLanguage,
+	"js":     javascript.GetLanguage,
+	"python": python.GetLanguage,
+	"ruby":   ruby.GetLanguage,
+	"rust":   rust.GetLanguage,
+}
+
+// nodes of the syntax tree are scopes, their delimiters have no text
+var nodeOpen, nodeClose = &Delimiter{Str: "", Open: true}, &Delimiter{Str: "", Open: false}
+
+func init() {
+	nodeOpen.Pair, nodeClose.Pair = nodeClose, nodeOpen
+	Engines = append(Engines, "treesitter")
+	engines["treesitter"] = newTreeSitterScanner
+}
+
+// treeSitterScanner parses the whole input with a real grammar once it's
+// complete, blocks and bodies in the syntax tree are the scopes
+type treeSitterScanner struct {
+	grammar *sitter.Language
+	indent  bool // scopes start on the line of the parent node, python style
+	lines   []*Line
+}
+
+func newTreeSitterScanner(lang *Language, opts *Options) (scanner, error) {
+	grammar, ok := grammars[lang.Name]
+	if !ok {
+		return nil, fmt.Errorf("no tree-sitter grammar for %s", lang.Name)
+	}
+	return &treeSitterScanner{grammar: grammar(), indent: lang.scanMode(opts.Mode) == "indent"}, nil
+}
+
+func (s *treeSitterScanner) scan(l *Line) Markers {
+	s.lines = append(s.lines, l)
+	return nil
+}
+
+// nothing settles until the whole input is parsed
+func (s *treeSitterScanner) settled() uint { return 0 }
+
+func (s *treeSitterScanner) finish() Markers {
+	var src bytes.Buffer
+	for _, l := range s.lines {
+		src.Write(l.Text)
+	}
+	parser := sitter.NewParser()
+	parser.SetLanguage(s.grammar)
+	tree, err := parser.ParseCtx(context.Background(), nil, src.Bytes())
+	if err != nil {
+		return nil
+	}
+	var markers Markers
+	s.walk(tree.RootNode(), nil, &markers)
+	return markers
+}
+
+// walk the tree in order so markers come out sorted and nested
+func (s *treeSitterScanner) walk(n, parent *sitter.Node, markers *Markers) {
+	scope := isScopeNode(n.Type()) && n.StartPoint().Row < n.EndPoint().Row
+	if scope {
+		start := n.StartPoint()
+		if s.indent && parent != nil {
+			start = parent.StartPoint()
+		}
+		*markers = append(*markers, &Marker{Delim: nodeOpen, Line: s.lines[start.Row],
+			Col: uint(start.Column), Name: n.Type()})
+	}
+	for i := 0; i < int(n.ChildCount()); i++ {
+		s.walk(n.Child(i), n, markers)
+	}
+	if scope {
+		*markers = append(*markers, s.closeMarker(n))
+	}
+}
+
+// the end of a node is exclusive, it may be the start of the next line
+func (s *treeSitterScanner) closeMarker(n *sitter.Node) *Marker {
+	end := n.EndPoint()
+	row, col := int(end.Row), int(end.Column)-1
+	if col < 0 || row >= len(s.lines) {
+		row--
+		col = len(bytes.TrimRight(s.lines[row].Text, "\r\n")) - 1
+	}
+	if col < 0 {
+		col = 0
+	}
+	return &Marker{Delim: nodeClose, Line: s.lines[row], Col: uint(col), Name: n.Type()}
+}
+
+// isScopeNode tells which kinds of nodes are blocks or bodies across grammars
+func isScopeNode(kind string) bool {
+	switch kind {
+	case "compound_statement", "declaration_list", "field_declaration_list",
+		"statement_block", "class_body", "object", "array":
+		return true
+	}
+	return strings.HasSuffix(kind, "block") || strings.HasSuffix(kind, "body")
+}
@@ -0,0 +1,131 @@
+package sgrep
+
+import (
+	"bytes"
+	"strings"
+)
+
+// tags open with <name and close with </name, names tell which pair
+var xmlOpen, xmlClose = &Delimiter{Str: "<", Open: true}, &Delimiter{Str: "</", Open: false}
+
+func init() {
+	xmlOpen.Pair, xmlClose.Pair = xmlClose, xmlOpen
+}
+
+// html elements that never have a closing tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// elements whose content is raw text, not markup
+var rawElements = map[string]bool{"script": true, "style": true}
+
+// xmlTag is a tag being read, it may span several lines
+type xmlTag struct {
+	line    *Line
+	col     int
+	name    string
+	closing bool
+}
+
+// xmlScanner makes scopes out of <tag>...</tag> pairs. Self-closing tags
+// are scopes of their own, comments, declarations and CDATA are skipped.
+type xmlScanner struct {
+	tag   *xmlTag // tag being read
+	quote byte    // quote of the attribute value being read
+	skip  string  // token ending the comment, declaration or CDATA we're in
+	raw   string  // closing tag of the raw text element we're in
+	next  uint
+}
+
+func (s *xmlScanner) scan(l *Line) Markers {
+	var markers Markers
+	line := l.Text
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case s.skip != "":
+			if bytes.HasPrefix(line[i:], []byte(s.skip)) {
+				i += len(s.skip) - 1
+				s.skip = ""
+			}
+		case s.tag != nil:
+			if s.quote != 0 {
+				if c == s.quote {
+					s.quote = 0
+				}
+			} else if c == '"' || c == '\'' {
+				s.quote = c
+			} else if c == '>' {
+				markers = append(markers, s.endTag(l, i)...)
+			}
+		case s.raw != "":
+			if len(line)-i >= len(s.raw) && strings.EqualFold(string(line[i:i+len(s.raw)]), s.raw) {
+				s.raw = ""
+				i-- // read the closing tag
+			}
+		case c == '<':
+			i = s.startTag(l, i)
+		}
+	}
+	s.next = l.Num + 1
+	if s.tag != nil {
+		s.next = s.tag.line.Num
+	}
+	return markers
+}
+
+// startTag begins reading whatever starts at the < in col i, returns the
+// last column consumed
+func (s *xmlScanner) startTag(l *Line, i int) int {
+	rest := l.Text[i:]
+	for _, skip := range [][2]string{{"<!--", "-->"}, {"<![CDATA[", "]]>"}, {"<?", "?>"}, {"<!", ">"}} {
+		if bytes.HasPrefix(rest, []byte(skip[0])) {
+			s.skip = skip[1]
+			return i + len(skip[0]) - 1
+		}
+	}
+	closing := len(rest) > 1 && rest[1] == '/'
+	start := 1
+	if closing {
+		start = 2
+	}
+	end := start
+	for end < len(rest) && isNameByte(rest[end]) {
+		end++
+	}
+	if end == start {
+		return i // just a <, not a tag
+	}
+	s.tag = &xmlTag{line: l, col: i, name: strings.ToLower(string(rest[start:end])), closing: closing}
+	return i + end - 1
+}
+
+// endTag finishes reading a tag at the > in col i
+func (s *xmlScanner) endTag(l *Line, i int) Markers {
+	tag := s.tag
+	s.tag = nil
+	switch {
+	case tag.closing:
+		return Markers{&Marker{Delim: xmlClose, Line: tag.line, Col: uint(tag.col), Name: tag.name}}
+	case i > 0 && l.Text[i-1] == '/':
+		return Markers{
+			&Marker{Delim: xmlOpen, Line: tag.line, Col: uint(tag.col), Name: tag.name},
+			&Marker{Delim: xmlClose, Line: l, Col: uint(i - 1), Name: tag.name}}
+	case voidElements[tag.name]:
+		return nil
+	}
+	if rawElements[tag.name] {
+		s.raw = "</" + tag.name
+	}
+	return Markers{&Marker{Delim: xmlOpen, Line: tag.line, Col: uint(tag.col), Name: tag.name}}
+}
+
+func (s *xmlScanner) settled() uint   { return s.next }
+func (s *xmlScanner) finish() Markers { return nil }
+
+func isNameByte(c byte) bool {
+	return isWordByte(c) || c == ':' || c == '-' || c == '.'
+}
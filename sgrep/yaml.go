@@ -0,0 +1,47 @@
+package sgrep
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// yamlKey matches the key of a mapping entry, quoted or not
+var yamlKey = regexp.MustCompile(`^("(?:[^"\\]|\\.)*"|'[^']*'|[^\s#'"-][^:#]*?|-[^\s:#][^:#]*?)\s*:(\s|$)`)
+
+// yamlFrame is a mapping or sequence open in a yaml document
+type yamlFrame struct {
+	path  string
+	items int // sequence items seen
+}
+
+// yamlPath is the path of what the line holds within parent, a key or the
+// next sequence item
+func yamlPath(parent *yamlFrame, line []byte, col int) string {
+	content := strings.TrimRight(string(line[col:]), "\r\n")
+	if content == "-" || strings.HasPrefix(content, "- ") {
+		parent.items++
+		return indexPath(parent.path, strconv.Itoa(parent.items-1))
+	}
+	m := yamlKey.FindStringSubmatch(content)
+	if m == nil {
+		return parent.path
+	}
+	key := m[1]
+	if unquoted, err := strconv.Unquote(key); err == nil && key[0] == '"' {
+		key = unquoted
+	} else if key[0] == '\'' {
+		key = strings.ReplaceAll(key[1:len(key)-1], "''", "'")
+	}
+	return keyPath(parent.path, key)
+}
+
+// yamlIndentation is the indentation of a line, sequence items count as
+// nested in their key even when they aren't indented
+func yamlIndentation(line []byte) (int, int, bool) {
+	indent, col, blank := indentation(line)
+	if rest := line[col:]; len(rest) > 0 && rest[0] == '-' && (len(rest) == 1 || rest[1] == ' ' || rest[1] == '\n' || rest[1] == '\r') {
+		indent++
+	}
+	return indent, col, blank
+}